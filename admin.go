@@ -0,0 +1,279 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrMessageNotFound = errors.New("message not found")
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+	// maxRecordedAttempts is the number of the latest failed attempts kept with message
+	maxRecordedAttempts = 10
+)
+
+// FailureRecorder is implemented by repositories storing failed publish attempts,
+// relay records every message it failed to publish
+type FailureRecorder interface {
+	RecordFailure(ctx context.Context, msg Message, err error) error
+}
+
+type MessageStatus string
+
+const (
+	StatusPending  MessageStatus = "pending"
+	StatusFailed   MessageStatus = "failed"
+	StatusConsumed MessageStatus = "consumed"
+	StatusExpired  MessageStatus = "expired"
+)
+
+// MessageRecord is a stored message with its publish attempts
+type MessageRecord struct {
+	Message
+	// Seq is position of message in outbox table, it increases in order messages are stored
+	Seq          int64
+	Status       MessageStatus
+	Failures     int
+	LastError    string
+	LastFailedAt *time.Time
+	// Attempts are the latest failed publish attempts, the oldest first
+	Attempts  []Attempt
+	ExpiredAt *time.Time
+}
+
+// Attempt is a failed publish attempt
+type Attempt struct {
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// MessageFilter selects stored messages, zero fields are ignored
+type MessageFilter struct {
+	Status        MessageStatus
+	EventType     string
+	Exchange      string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// AfterSeq selects messages stored after message of Seq, use it to page through messages
+	AfterSeq int64
+	Limit    int
+	Offset   int
+}
+
+// where builds filter condition with placeholders starting from $1
+func (f MessageFilter) where() (string, []any) {
+	conds := make([]string, 0)
+	args := make([]any, 0)
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	switch f.Status {
+	case StatusPending:
+		add("consumed = $%d AND failures = 0", statusNotConsumed)
+	case StatusFailed:
+		add("consumed = $%d AND failures > 0", statusNotConsumed)
+	case StatusConsumed:
+		add("consumed = $%d AND expired_at IS NULL", statusConsumed)
+	case StatusExpired:
+		add("consumed = $%d AND expired_at IS NOT NULL", statusConsumed)
+	}
+	if f.EventType != "" {
+		add("event_type = $%d", f.EventType)
+	}
+	if f.Exchange != "" {
+		add("exchange = $%d", f.Exchange)
+	}
+	if !f.CreatedAfter.IsZero() {
+		add("created_at >= $%d", f.CreatedAfter)
+	}
+	if !f.CreatedBefore.IsZero() {
+		add("created_at < $%d", f.CreatedBefore)
+	}
+	if f.AfterSeq > 0 {
+		add("id > $%d", f.AfterSeq)
+	}
+
+	if len(conds) == 0 {
+		return "TRUE", args
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+const recordColumns = `id, event_id, event_type, exchange, routing_key, partition_key, payload, consumed, created_at, headers,
+codec, compression, key_id, data_key, claim_check, publish_at, expires_at, expired_at, priority, failures, last_error, last_failed_at,
+attempts`
+
+// List returns stored messages matching filter in order they were stored
+func (r *Repository) List(ctx context.Context, filter MessageFilter) ([]MessageRecord, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	where, args := filter.where()
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY id ASC LIMIT $%d OFFSET $%d",
+		recordColumns, TableName, where, len(args)+1, len(args)+2)
+
+	return r.queryRecords(ctx, query, append(args, limit, filter.Offset)...)
+}
+
+// Get returns stored message by id
+func (r *Repository) Get(ctx context.Context, id string) (MessageRecord, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE event_id = $1", recordColumns, TableName)
+
+	records, err := r.queryRecords(ctx, query, id)
+	if err != nil {
+		return MessageRecord{}, err
+	}
+
+	if len(records) == 0 {
+		return MessageRecord{}, fmt.Errorf("%w: %s", ErrMessageNotFound, id)
+	}
+
+	return records[0], nil
+}
+
+// Requeue makes messages pending again and resets their failures.
+// Expired messages are requeued without expiry, expiry still ahead is kept.
+func (r *Repository) Requeue(ctx context.Context, ids []string) (int64, error) {
+	query := fmt.Sprintf(`
+UPDATE %s SET consumed = $1, failures = 0, last_error = NULL, last_failed_at = NULL, expired_at = NULL,
+              expires_at = CASE WHEN expires_at <= now() THEN NULL ELSE expires_at END
+WHERE event_id = ANY($2) RETURNING event_id`,
+		TableName,
+	)
+
+	return r.count(ctx, query, statusNotConsumed, ids)
+}
+
+// Skip marks messages consumed without publishing them
+func (r *Repository) Skip(ctx context.Context, ids []string) (int64, error) {
+	query := fmt.Sprintf("UPDATE %s SET consumed = $1 WHERE event_id = ANY($2) RETURNING event_id", TableName)
+
+	return r.count(ctx, query, statusConsumed, ids)
+}
+
+// Delete removes messages
+func (r *Repository) Delete(ctx context.Context, ids []string) (int64, error) {
+	query := fmt.Sprintf("DELETE FROM %s WHERE event_id = ANY($1) RETURNING event_id", TableName)
+
+	return r.count(ctx, query, ids)
+}
+
+// RecordFailure counts failed publish attempt and appends it to the latest attempts of message
+func (r *Repository) RecordFailure(ctx context.Context, msg Message, err error) error {
+	query := fmt.Sprintf(`
+UPDATE %s SET failures = failures + 1, last_error = $1, last_failed_at = CURRENT_TIMESTAMP,
+    attempts = (
+        SELECT COALESCE(jsonb_agg(attempt ORDER BY n), '[]'::jsonb) FROM (
+            SELECT attempt, n
+            FROM jsonb_array_elements(attempts || jsonb_build_array(jsonb_build_object('error', $1::text, 'failed_at', now())))
+                WITH ORDINALITY AS a(attempt, n)
+            ORDER BY n DESC LIMIT $3
+        ) latest
+    )
+WHERE event_id = $2`, TableName)
+
+	if execErr := r.db.Exec(ctx, query, err.Error(), msg.ID, maxRecordedAttempts); execErr != nil {
+		return fmt.Errorf("while recording failure: %w", execErr)
+	}
+
+	return nil
+}
+
+// count runs data modifying query with RETURNING clause and returns number of affected rows
+func (r *Repository) count(ctx context.Context, query string, args ...any) (int64, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf("WITH affected AS (%s) SELECT count(*) FROM affected", query), args...)
+	if err != nil {
+		return 0, fmt.Errorf("while executing query: %w", err)
+	}
+	defer rows.Close() //nolint
+
+	var n int64
+	if rows.Next() {
+		if err = rows.Scan(&n); err != nil {
+			return 0, fmt.Errorf("while scan affected rows: %w", err)
+		}
+	}
+
+	return n, nil
+}
+
+func (r *Repository) queryRecords(ctx context.Context, query string, args ...any) ([]MessageRecord, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("while quering messages: %w", err)
+	}
+	defer rows.Close() //nolint
+
+	records := make([]MessageRecord, 0)
+	for rows.Next() {
+		var (
+			record       MessageRecord
+			payload      []byte
+			lastError    sql.NullString
+			lastFailedAt sql.NullTime
+			expiredAt    sql.NullTime
+			attempts     []byte
+		)
+
+		err = rows.Scan(
+			&record.Seq, &record.ID, &record.EventType, &record.Exchange, &record.RoutingKey, &record.PartitionKey, &payload,
+			&record.Consumed, &record.CreatedAt, &record.Headers, &record.Codec, &record.Compression, &record.keyID, &record.dataKey, &record.claimCheck, &record.PublishAt, &record.ExpiresAt, &expiredAt, &record.Priority, &record.Failures, &lastError, &lastFailedAt,
+			&attempts,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("while scan messages: %w", err)
+		}
+
+		record.Payload = payload
+		if r.keys != nil || r.blobs != nil {
+			if err = r.restore(ctx, &record.Message); err != nil {
+				return nil, fmt.Errorf("while restoring message %s: %w", record.ID, err)
+			}
+		}
+		record.LastError = lastError.String
+		if lastFailedAt.Valid {
+			record.LastFailedAt = &lastFailedAt.Time
+		}
+		if err = json.Unmarshal(attempts, &record.Attempts); err != nil {
+			return nil, fmt.Errorf("while decoding attempts of message %s: %w", record.ID, err)
+		}
+
+		if expiredAt.Valid {
+			record.ExpiredAt = &expiredAt.Time
+		}
+
+		switch {
+		case record.ExpiredAt != nil:
+			record.Status = StatusExpired
+		case record.Consumed:
+			record.Status = StatusConsumed
+		case record.Failures > 0:
+			record.Status = StatusFailed
+		default:
+			record.Status = StatusPending
+		}
+
+		records = append(records, record)
+	}
+
+	if err = rowsErr(rows); err != nil {
+		return nil, fmt.Errorf("while reading messages: %w", err)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,277 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdminRepository is the storage used by admin handler, implemented by Repository
+type AdminRepository interface {
+	List(ctx context.Context, filter MessageFilter) ([]MessageRecord, error)
+	Get(ctx context.Context, id string) (MessageRecord, error)
+	Requeue(ctx context.Context, ids []string) (int64, error)
+	Skip(ctx context.Context, ids []string) (int64, error)
+	Delete(ctx context.Context, ids []string) (int64, error)
+}
+
+type AdminAction string
+
+const (
+	AdminList    AdminAction = "list"
+	AdminGet     AdminAction = "get"
+	AdminRequeue AdminAction = "requeue"
+	AdminSkip    AdminAction = "skip"
+	AdminDelete  AdminAction = "delete"
+)
+
+// AdminAuthorizer reports whether request may perform action
+type AdminAuthorizer func(r *http.Request, action AdminAction) bool
+
+// NewAdminHandler returns handler inspecting and managing outbox messages:
+//
+//	GET    /messages?status=&event_type=&exchange=&older_than=&newer_than=&limit=&offset=
+//	GET    /messages/{id}
+//	POST   /messages/{id}/requeue
+//	POST   /messages/{id}/skip
+//	DELETE /messages/{id}
+//
+// Every request is checked with authorize. Payloads are returned decrypted, so when authorize is nil
+// every request is denied.
+func NewAdminHandler(repo AdminRepository, authorize AdminAuthorizer) http.Handler {
+	if authorize == nil {
+		authorize = func(r *http.Request, action AdminAction) bool {
+			return false
+		}
+	}
+
+	return &adminHandler{repo: repo, authorize: authorize}
+}
+
+type adminHandler struct {
+	repo      AdminRepository
+	authorize AdminAuthorizer
+}
+
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	if parts[0] != "messages" || len(parts) > 3 {
+		writeAdminError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	if len(parts) > 1 && !validUUID(parts[1]) {
+		writeAdminError(w, http.StatusBadRequest, errors.New("message id must be a uuid"))
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		h.handle(w, r, AdminList, h.list)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		h.handle(w, r, AdminGet, func(w http.ResponseWriter, r *http.Request) {
+			h.get(w, r, parts[1])
+		})
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		h.handle(w, r, AdminDelete, func(w http.ResponseWriter, r *http.Request) {
+			h.modify(w, r, parts[1], h.repo.Delete)
+		})
+	case len(parts) == 3 && r.Method == http.MethodPost && parts[2] == string(AdminRequeue):
+		h.handle(w, r, AdminRequeue, func(w http.ResponseWriter, r *http.Request) {
+			h.modify(w, r, parts[1], h.repo.Requeue)
+		})
+	case len(parts) == 3 && r.Method == http.MethodPost && parts[2] == string(AdminSkip):
+		h.handle(w, r, AdminSkip, func(w http.ResponseWriter, r *http.Request) {
+			h.modify(w, r, parts[1], h.repo.Skip)
+		})
+	default:
+		writeAdminError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func (h *adminHandler) handle(w http.ResponseWriter, r *http.Request, action AdminAction, fn http.HandlerFunc) {
+	if !h.authorize(r, action) {
+		writeAdminError(w, http.StatusForbidden, errors.New("forbidden"))
+		return
+	}
+
+	fn(w, r)
+}
+
+func (h *adminHandler) list(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseMessageFilter(r)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	records, err := h.repo.List(r.Context(), filter)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	messages := make([]adminMessage, 0, len(records))
+	for _, record := range records {
+		messages = append(messages, newAdminMessage(record))
+	}
+
+	writeAdminJSON(w, http.StatusOK, map[string]any{"messages": messages})
+}
+
+func (h *adminHandler) get(w http.ResponseWriter, r *http.Request, id string) {
+	record, err := h.repo.Get(r.Context(), id)
+	if errors.Is(err, ErrMessageNotFound) {
+		writeAdminError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, newAdminMessage(record))
+}
+
+func (h *adminHandler) modify(w http.ResponseWriter, r *http.Request, id string, fn func(ctx context.Context, ids []string) (int64, error)) {
+	n, err := fn(r.Context(), []string{id})
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if n == 0 {
+		writeAdminError(w, http.StatusNotFound, ErrMessageNotFound)
+		return
+	}
+
+	writeAdminJSON(w, http.StatusOK, map[string]any{"affected": n})
+}
+
+func parseMessageFilter(r *http.Request) (MessageFilter, error) {
+	q := r.URL.Query()
+	filter := MessageFilter{
+		Status:    MessageStatus(q.Get("status")),
+		EventType: q.Get("event_type"),
+		Exchange:  q.Get("exchange"),
+	}
+
+	switch filter.Status {
+	case "", StatusPending, StatusFailed, StatusConsumed, StatusExpired:
+	default:
+		return MessageFilter{}, errors.New("status must be one of pending, failed, consumed, expired")
+	}
+
+	now := time.Now()
+	if v := q.Get("older_than"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return MessageFilter{}, errors.New("older_than must be a duration")
+		}
+		filter.CreatedBefore = now.Add(-d)
+	}
+	if v := q.Get("newer_than"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return MessageFilter{}, errors.New("newer_than must be a duration")
+		}
+		filter.CreatedAfter = now.Add(-d)
+	}
+
+	var err error
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 0 {
+			return MessageFilter{}, errors.New("limit must be a non-negative number")
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if filter.Offset, err = strconv.Atoi(v); err != nil || filter.Offset < 0 {
+			return MessageFilter{}, errors.New("offset must be a non-negative number")
+		}
+	}
+
+	return filter, nil
+}
+
+// adminMessage is JSON representation of MessageRecord
+type adminMessage struct {
+	ID           string          `json:"id"`
+	EventType    string          `json:"event_type"`
+	Exchange     string          `json:"exchange"`
+	RoutingKey   string          `json:"routing_key"`
+	PartitionKey *int64          `json:"partition_key,omitempty"`
+	Codec        string          `json:"codec"`
+	Priority     int             `json:"priority"`
+	Payload      json.RawMessage `json:"payload"`
+	Headers      Headers         `json:"headers,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	Status       MessageStatus   `json:"status"`
+	Failures     int             `json:"failures"`
+	LastError    string          `json:"last_error,omitempty"`
+	LastFailedAt *time.Time      `json:"last_failed_at,omitempty"`
+	Attempts     []Attempt       `json:"attempts,omitempty"`
+	ExpiredAt    *time.Time      `json:"expired_at,omitempty"`
+}
+
+func newAdminMessage(record MessageRecord) adminMessage {
+	m := adminMessage{
+		ID:           record.ID,
+		EventType:    record.EventType,
+		Exchange:     record.Exchange,
+		RoutingKey:   record.RoutingKey,
+		Codec:        record.Codec,
+		Priority:     record.Priority,
+		Headers:      record.Headers,
+		CreatedAt:    record.CreatedAt,
+		Status:       record.Status,
+		Failures:     record.Failures,
+		LastError:    record.LastError,
+		LastFailedAt: record.LastFailedAt,
+		Attempts:     record.Attempts,
+		ExpiredAt:    record.ExpiredAt,
+	}
+
+	if record.PartitionKey.Valid {
+		m.PartitionKey = &record.PartitionKey.Int64
+	}
+	if payload, err := jsonPayload(record.Message); err == nil {
+		m.Payload = payload
+	}
+
+	return m
+}
+
+// validUUID reports whether s is uuid in canonical form
+func validUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+
+	for i, c := range s {
+		switch {
+		case i == 8 || i == 13 || i == 18 || i == 23:
+			if c != '-' {
+				return false
+			}
+		case '0' <= c && c <= '9', 'a' <= c && c <= 'f', 'A' <= c && c <= 'F':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJSON(w, status, map[string]string{"error": err.Error()})
+}
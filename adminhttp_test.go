@@ -0,0 +1,198 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// adminRepositoryMock keeps message records in memory
+type adminRepositoryMock struct {
+	records map[string]MessageRecord
+	filter  MessageFilter
+}
+
+func (m *adminRepositoryMock) List(ctx context.Context, filter MessageFilter) ([]MessageRecord, error) {
+	m.filter = filter
+
+	records := make([]MessageRecord, 0)
+	for _, r := range m.records {
+		if filter.Status == "" || r.Status == filter.Status {
+			records = append(records, r)
+		}
+	}
+
+	return records, nil
+}
+
+func (m *adminRepositoryMock) Get(ctx context.Context, id string) (MessageRecord, error) {
+	r, ok := m.records[id]
+	if !ok {
+		return MessageRecord{}, ErrMessageNotFound
+	}
+
+	return r, nil
+}
+
+func (m *adminRepositoryMock) Requeue(ctx context.Context, ids []string) (int64, error) {
+	return m.update(ids, StatusPending), nil
+}
+
+func (m *adminRepositoryMock) Skip(ctx context.Context, ids []string) (int64, error) {
+	return m.update(ids, StatusConsumed), nil
+}
+
+func (m *adminRepositoryMock) Delete(ctx context.Context, ids []string) (int64, error) {
+	var n int64
+	for _, id := range ids {
+		if _, ok := m.records[id]; ok {
+			delete(m.records, id)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (m *adminRepositoryMock) update(ids []string, status MessageStatus) int64 {
+	var n int64
+	for _, id := range ids {
+		if r, ok := m.records[id]; ok {
+			r.Status = status
+			m.records[id] = r
+			n++
+		}
+	}
+
+	return n
+}
+
+func newAdminRepositoryMock() *adminRepositoryMock {
+	return &adminRepositoryMock{records: map[string]MessageRecord{
+		pendingID: {Message: Message{ID: pendingID, EventType: "Created"}, Status: StatusPending},
+		failedID: {Message: Message{ID: failedID, EventType: "Created"}, Status: StatusFailed, Failures: 3, LastError: "broker down",
+			Attempts: []Attempt{{Error: "broker down", FailedAt: time.Now()}}},
+	}}
+}
+
+const (
+	pendingID = "f53ec986-345f-48a4-b248-430a7d7f3401"
+	failedID  = "f53ec986-345f-48a4-b248-430a7d7f3402"
+	unknownID = "f53ec986-345f-48a4-b248-430a7d7f3403"
+)
+
+// readOnly allows listing and getting messages
+func readOnly(r *http.Request, action AdminAction) bool {
+	return action == AdminList || action == AdminGet
+}
+
+func TestAdminHandler(t *testing.T) {
+	t.Run("Test list with filters", func(t *testing.T) {
+		repo := newAdminRepositoryMock()
+		h := NewAdminHandler(repo, readOnly)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages?status=failed&exchange=orders&older_than=1h&limit=10", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		resp := struct {
+			Messages []adminMessage `json:"messages"`
+		}{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Len(t, resp.Messages, 1)
+		assert.Equal(t, "broker down", resp.Messages[0].LastError)
+		assert.Equal(t, "orders", repo.filter.Exchange)
+		assert.Equal(t, 10, repo.filter.Limit)
+		assert.False(t, repo.filter.CreatedBefore.IsZero())
+	})
+
+	t.Run("Test invalid filter", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		NewAdminHandler(newAdminRepositoryMock(), readOnly).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages?status=unknown", nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		for _, query := range []string{"limit=-1", "offset=-1", "limit=ten"} {
+			rec = httptest.NewRecorder()
+			NewAdminHandler(newAdminRepositoryMock(), readOnly).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages?"+query, nil))
+			assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+		}
+	})
+
+	t.Run("Test get", func(t *testing.T) {
+		h := NewAdminHandler(newAdminRepositoryMock(), readOnly)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages/"+failedID, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		msg := adminMessage{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &msg))
+		assert.Equal(t, 3, msg.Failures)
+		assert.Len(t, msg.Attempts, 1)
+
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages/"+unknownID, nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages/3", nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Test requests are denied without authorizer", func(t *testing.T) {
+		h := NewAdminHandler(newAdminRepositoryMock(), nil)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages", nil))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/messages/"+failedID, nil))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("Test modifications require authorization", func(t *testing.T) {
+		repo := newAdminRepositoryMock()
+
+		rec := httptest.NewRecorder()
+		NewAdminHandler(repo, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/messages/"+failedID+"/requeue", nil))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		authorize := func(r *http.Request, action AdminAction) bool {
+			return r.Header.Get("X-Operator") != ""
+		}
+		h := NewAdminHandler(repo, authorize)
+
+		req := httptest.NewRequest(http.MethodPost, "/messages/"+failedID+"/requeue", nil)
+		req.Header.Set("X-Operator", "ops")
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, StatusPending, repo.records[failedID].Status)
+
+		req = httptest.NewRequest(http.MethodPost, "/messages/"+pendingID+"/skip", nil)
+		req.Header.Set("X-Operator", "ops")
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, StatusConsumed, repo.records[pendingID].Status)
+
+		req = httptest.NewRequest(http.MethodDelete, "/messages/"+pendingID, nil)
+		req.Header.Set("X-Operator", "ops")
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NotContains(t, repo.records, pendingID)
+
+		req = httptest.NewRequest(http.MethodDelete, "/messages/"+pendingID, nil)
+		req.Header.Set("X-Operator", "ops")
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
@@ -0,0 +1,194 @@
+package outbox
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+func (s CircuitState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// CircuitBreaker stops publishing after consecutive failures.
+//
+// Breaker opens after failureThreshold consecutive failures and rejects publishing with ErrCircuitOpen.
+// After openTimeout it becomes half-open and lets halfOpenProbes publishes through,
+// it closes when all of them succeed and opens again on the first failure.
+type CircuitBreaker struct {
+	failureThreshold int
+	openTimeout      time.Duration
+	halfOpenProbes   int
+
+	state      CircuitState
+	generation uint64
+	failures   int
+	openedAt   time.Time
+	probes     int
+	successes  int
+	listeners  []func(from, to CircuitState)
+	pending    []stateTransition
+	mu         sync.Mutex
+}
+
+type stateTransition struct {
+	from, to CircuitState
+}
+
+func NewCircuitBreaker(failureThreshold int, openTimeout time.Duration, halfOpenProbes int) *CircuitBreaker {
+	if failureThreshold < 1 {
+		failureThreshold = 1
+	}
+	if halfOpenProbes < 1 {
+		halfOpenProbes = 1
+	}
+
+	return &CircuitBreaker{
+		failureThreshold: failureThreshold,
+		openTimeout:      openTimeout,
+		halfOpenProbes:   halfOpenProbes,
+	}
+}
+
+// OnStateChange registers listener called on every state transition
+func (cb *CircuitBreaker) OnStateChange(fn func(from, to CircuitState)) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.listeners = append(cb.listeners, fn)
+}
+
+// State returns current state, open breaker becomes half-open once openTimeout passed
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	return cb.currentState()
+}
+
+// Allow reports whether a call may proceed and returns generation of state the call started in,
+// every allowed call must be reported with Done
+func (cb *CircuitBreaker) Allow() (uint64, error) {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	switch cb.currentState() {
+	case CircuitOpen:
+		return cb.generation, ErrCircuitOpen
+	case CircuitHalfOpen:
+		if cb.probes >= cb.halfOpenProbes {
+			return cb.generation, ErrCircuitOpen
+		}
+		cb.probes++
+	}
+
+	return cb.generation, nil
+}
+
+// Done reports result of allowed call started in generation,
+// results of calls started before the last state change are ignored
+func (cb *CircuitBreaker) Done(generation uint64, err error) {
+	cb.mu.Lock()
+	defer cb.unlock()
+
+	state := cb.currentState()
+	if generation != cb.generation {
+		return
+	}
+
+	if err != nil {
+		cb.failures++
+		if state == CircuitHalfOpen || cb.failures >= cb.failureThreshold {
+			cb.setState(CircuitOpen)
+		}
+		return
+	}
+
+	cb.failures = 0
+	if state == CircuitHalfOpen {
+		cb.successes++
+		if cb.successes >= cb.halfOpenProbes {
+			cb.setState(CircuitClosed)
+		}
+	}
+}
+
+// Middleware rejects publishing with ErrCircuitOpen while breaker is open
+func (cb *CircuitBreaker) Middleware() PublisherMiddleware {
+	return func(next Publisher) Publisher {
+		return PublisherFunc(func(exchange, topic string, message Message) error {
+			generation, err := cb.Allow()
+			if err != nil {
+				return err
+			}
+
+			err = next.Publish(exchange, topic, message)
+			cb.Done(generation, err)
+
+			return err
+		})
+	}
+}
+
+func (cb *CircuitBreaker) currentState() CircuitState {
+	if cb.state == CircuitOpen && time.Since(cb.openedAt) >= cb.openTimeout {
+		cb.setState(CircuitHalfOpen)
+	}
+
+	return cb.state
+}
+
+func (cb *CircuitBreaker) setState(state CircuitState) {
+	from := cb.state
+	cb.state = state
+	cb.generation++
+	cb.probes = 0
+	cb.successes = 0
+
+	switch state {
+	case CircuitOpen:
+		cb.openedAt = time.Now()
+	case CircuitClosed:
+		cb.failures = 0
+	}
+
+	if from != state {
+		cb.pending = append(cb.pending, stateTransition{from: from, to: state})
+	}
+}
+
+// unlock releases breaker and notifies listeners about transitions made while it was locked
+func (cb *CircuitBreaker) unlock() {
+	pending, listeners := cb.pending, cb.listeners
+	cb.pending = nil
+	cb.mu.Unlock()
+
+	for _, t := range pending {
+		for _, fn := range listeners {
+			fn(t.from, t.to)
+		}
+	}
+}
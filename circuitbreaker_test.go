@@ -0,0 +1,150 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Run("Test breaker opens, probes and closes", func(t *testing.T) {
+		var transitions []CircuitState
+		cb := NewCircuitBreaker(2, 50*time.Millisecond, 1)
+		cb.OnStateChange(func(from, to CircuitState) {
+			transitions = append(transitions, to)
+		})
+
+		failing := cb.Middleware()(PublisherFunc(func(exchange, topic string, message Message) error {
+			return errors.New("broker down")
+		}))
+		working := cb.Middleware()(&PublisherMock{})
+
+		assert.Error(t, failing.Publish("test", "test", Message{}))
+		assert.Equal(t, CircuitClosed, cb.State())
+		assert.Error(t, failing.Publish("test", "test", Message{}))
+		assert.Equal(t, CircuitOpen, cb.State())
+		assert.ErrorIs(t, working.Publish("test", "test", Message{}), ErrCircuitOpen)
+
+		time.Sleep(60 * time.Millisecond)
+		assert.Equal(t, CircuitHalfOpen, cb.State())
+		assert.Error(t, failing.Publish("test", "test", Message{}))
+		assert.Equal(t, CircuitOpen, cb.State())
+
+		time.Sleep(60 * time.Millisecond)
+		assert.NoError(t, working.Publish("test", "test", Message{}))
+		assert.Equal(t, CircuitClosed, cb.State())
+
+		assert.Equal(t, []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}, transitions)
+	})
+
+	t.Run("Test results of calls started before state change are ignored", func(t *testing.T) {
+		cb := NewCircuitBreaker(1, 20*time.Millisecond, 1)
+
+		slow, err := cb.Allow()
+		assert.NoError(t, err)
+
+		failed, err := cb.Allow()
+		assert.NoError(t, err)
+		cb.Done(failed, errors.New("broker down"))
+		assert.Equal(t, CircuitOpen, cb.State())
+
+		time.Sleep(30 * time.Millisecond)
+		cb.Done(slow, nil)
+		assert.Equal(t, CircuitHalfOpen, cb.State())
+
+		probe, err := cb.Allow()
+		assert.NoError(t, err)
+		cb.Done(probe, nil)
+		assert.Equal(t, CircuitClosed, cb.State())
+	})
+}
+
+// countingRepositoryMock counts fetches
+type countingRepositoryMock struct {
+	RepositoryMock
+	fetches int
+	mu      sync.Mutex
+}
+
+func (m *countingRepositoryMock) Fetch(ctx context.Context, batchSize BatchSize) <-chan Message {
+	m.mu.Lock()
+	m.fetches++
+	m.mu.Unlock()
+
+	return m.RepositoryMock.Fetch(ctx, batchSize)
+}
+
+func TestRelay_CircuitBreaker(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Millisecond*300)
+	defer cancel()
+
+	cb := NewCircuitBreaker(1, time.Hour, 1)
+	p := PublisherFunc(func(exchange, topic string, message Message) error {
+		return errors.New("broker down")
+	})
+	r := &countingRepositoryMock{RepositoryMock: RepositoryMock{Messages: GenerateMessages(10)}}
+
+	var states []CircuitState
+	hooks := Hooks{OnCircuitStateChange: func(from, to CircuitState) {
+		states = append(states, to)
+	}}
+
+	relay := NewRelay(r, p, runtime.NumCPU(), time.Millisecond,
+		WithCircuitBreaker(cb), WithHooks(hooks), WithPublisherMiddlewares(), WithLogger(NopLogger{}))
+	assert.NoError(t, relay.Run(ctx, BatchSize(10)))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	assert.Equal(t, 1, r.fetches)
+	assert.Equal(t, []CircuitState{CircuitOpen}, states)
+	assert.Empty(t, r.Consumed)
+}
+
+// failureRecorderMock records publish failures
+type failureRecorderMock struct {
+	RepositoryMock
+	failures []string
+}
+
+func (m *failureRecorderMock) RecordFailure(ctx context.Context, msg Message, err error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.failures = append(m.failures, msg.ID)
+
+	return nil
+}
+
+func TestRelay_CircuitBreakerInsideRetry(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Millisecond*300)
+	defer cancel()
+
+	cb := NewCircuitBreaker(2, time.Hour, 1)
+	p := &failingPublisherMock{failures: 1000}
+	r := &failureRecorderMock{RepositoryMock: RepositoryMock{Messages: GenerateMessages(10)}}
+
+	var errs []error
+	var mu sync.Mutex
+	handler := func(ctx context.Context, err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+
+	relay := NewRelay(r, p, 1, time.Millisecond, WithCircuitBreaker(cb), WithErrorHandler(handler),
+		WithPublisherMiddlewares(RetryMiddleware(5, time.Millisecond)), WithLogger(NopLogger{}))
+	assert.NoError(t, relay.Run(ctx, BatchSize(10)))
+
+	// breaker counts every attempt and opens while the first message is retried,
+	// rejected attempt is not retried nor recorded as publish failure
+	assert.Equal(t, CircuitOpen, cb.State())
+	assert.Equal(t, 2, p.attempts)
+	assert.Empty(t, errs)
+	assert.Empty(t, r.failures)
+	assert.Empty(t, r.Consumed)
+}
@@ -0,0 +1,124 @@
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// HeaderClaimCheck is set to blob key of messages fetched without rehydration
+const HeaderClaimCheck = "x-claim-check"
+
+// BlobStore stores payloads moved out of outbox table.
+// Blobs are not deleted with messages, since replayed copies share them, expire them by store means.
+// Blobs are stored before transaction of their messages commits, so rolled back transactions leave
+// blobs not referenced by claim_check column of outbox table, they are safe to delete.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// FileBlobStore stores blobs as files of a directory
+type FileBlobStore struct {
+	dir string
+}
+
+// NewFileBlobStore creates dir if it does not exist
+func NewFileBlobStore(dir string) (*FileBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("while creating blob directory: %w", err)
+	}
+
+	return &FileBlobStore{dir: dir}, nil
+}
+
+func (s *FileBlobStore) Put(_ context.Context, key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".blob-*")
+	if err != nil {
+		return fmt.Errorf("while creating blob: %w", err)
+	}
+	defer os.Remove(tmp.Name()) //nolint
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close() //nolint
+		return fmt.Errorf("while writing blob: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("while writing blob: %w", err)
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("while storing blob: %w", err)
+	}
+
+	return nil
+}
+
+func (s *FileBlobStore) Get(_ context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while reading blob: %w", err)
+	}
+
+	return data, nil
+}
+
+func (s *FileBlobStore) path(key string) (string, error) {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+
+	return filepath.Join(s.dir, key), nil
+}
+
+// checkPayload moves payload to blob store leaving blob key in message.
+// Key is unique per call, so persisting message with id of a stored one never overwrites its blob.
+func checkPayload(ctx context.Context, store BlobStore, msg *Message, payload []byte) error {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Errorf("while generating blob key: %w", err)
+	}
+
+	key := msg.ID + "." + hex.EncodeToString(suffix)
+	if err := store.Put(ctx, key, payload); err != nil {
+		return fmt.Errorf("while storing payload: %w", err)
+	}
+
+	msg.Payload = []byte{}
+	msg.claimCheck = key
+
+	return nil
+}
+
+// rehydratePayload replaces payload of message by blob it references
+func rehydratePayload(ctx context.Context, store BlobStore, msg *Message) error {
+	payload, err := store.Get(ctx, msg.claimCheck)
+	if err != nil {
+		return fmt.Errorf("while loading payload: %w", err)
+	}
+
+	msg.Payload = payload
+	msg.claimCheck = ""
+
+	return nil
+}
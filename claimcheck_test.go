@@ -0,0 +1,91 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileBlobStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileBlobStore(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, store.Put(ctx, "1", []byte("payload")))
+	data, err := store.Get(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(data))
+
+	_, err = store.Get(ctx, "2")
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+
+	assert.Error(t, store.Put(ctx, "../1", []byte("payload")))
+}
+
+func TestClaimCheck(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewFileBlobStore(t.TempDir())
+	require.NoError(t, err)
+
+	cfg := newPersisterConfig([]PersisterOption{WithClaimCheck(store, 100)})
+	document := strings.Repeat("a", 200)
+
+	large := Message{ID: "large", Payload: map[string]string{"document": document}}
+	require.NoError(t, cfg.prepare(ctx, &large))
+	assert.True(t, strings.HasPrefix(large.claimCheck, "large."))
+	assert.Empty(t, large.Payload)
+
+	small := Message{ID: "small", Payload: map[string]string{"a": "b"}}
+	require.NoError(t, cfg.prepare(ctx, &small))
+	assert.Empty(t, small.claimCheck)
+
+	t.Run("Test payload of threshold size is checked", func(t *testing.T) {
+		msg := Message{ID: "exact", Payload: strings.Repeat("a", 100)}
+		require.NoError(t, newPersisterConfig([]PersisterOption{WithClaimCheck(store, 100)}).prepare(ctx, &msg))
+		assert.NotEmpty(t, msg.claimCheck)
+	})
+
+	t.Run("Test blob of stored message is not overwritten", func(t *testing.T) {
+		again := Message{ID: "large", Payload: strings.Repeat("b", 200)}
+		require.NoError(t, cfg.prepare(ctx, &again))
+		assert.NotEqual(t, large.claimCheck, again.claimCheck)
+
+		blob, err := store.Get(ctx, large.claimCheck)
+		require.NoError(t, err)
+		assert.Contains(t, string(blob), document)
+	})
+
+	t.Run("Test payload is rehydrated", func(t *testing.T) {
+		fetched := large
+		require.NoError(t, (&Repository{blobs: store}).decode(ctx, &fetched))
+		assert.JSONEq(t, `{"document": "`+document+`"}`, string(fetched.Payload.(json.RawMessage)))
+		assert.Empty(t, fetched.Headers)
+	})
+
+	t.Run("Test reference is published without blob store", func(t *testing.T) {
+		fetched := large
+		require.NoError(t, (&Repository{}).decode(ctx, &fetched))
+		assert.Equal(t, large.claimCheck, fetched.Headers[HeaderClaimCheck])
+		assert.Empty(t, fetched.Payload)
+	})
+
+	t.Run("Test encrypted payload is stored encrypted", func(t *testing.T) {
+		keys, err := NewLocalKeyProvider("key-1", make([]byte, 32))
+		require.NoError(t, err)
+		cfg := newPersisterConfig([]PersisterOption{WithEncryption(keys), WithClaimCheck(store, 0)})
+
+		msg := Message{ID: "secret", Payload: `{"email":"user@example.com"}`}
+		require.NoError(t, cfg.prepare(ctx, &msg))
+
+		blob, err := store.Get(ctx, msg.claimCheck)
+		require.NoError(t, err)
+		assert.NotContains(t, string(blob), "user@example.com")
+
+		require.NoError(t, (&Repository{blobs: store, keys: keys}).decode(ctx, &msg))
+		assert.Equal(t, `{"email":"user@example.com"}`, string(msg.Payload.(json.RawMessage)))
+	})
+}
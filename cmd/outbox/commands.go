@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vsvp21/outbox/v5"
+)
+
+func migrate(ctx context.Context, db *pgxpool.Pool, args []string) error {
+	if err := flag.NewFlagSet("migrate", flag.ContinueOnError).Parse(args); err != nil {
+		return err
+	}
+
+	applied, err := outbox.Migrate(ctx, outbox.NewPGXAdapter(db))
+	for _, v := range applied {
+		fmt.Printf("applied migration %d\n", v)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Println("schema is up to date")
+	}
+
+	return nil
+}
+
+func stats(ctx context.Context, db *pgxpool.Pool, args []string) error {
+	if err := flag.NewFlagSet("stats", flag.ContinueOnError).Parse(args); err != nil {
+		return err
+	}
+
+	rows, err := outbox.NewRepository(outbox.NewPGXAdapter(db)).Stats(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "EXCHANGE\tEVENT TYPE\tPENDING\tSCHEDULED\tFAILED\tCONSUMED\tEXPIRED\tOLDEST PENDING AGE")
+	for _, s := range rows {
+		age := "-"
+		if !s.OldestPendingAt.IsZero() {
+			age = time.Since(s.OldestPendingAt).Truncate(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%s\n",
+			s.Exchange, s.EventType, s.Pending, s.Scheduled, s.Failed, s.Consumed, s.Expired, age)
+	}
+
+	return w.Flush()
+}
+
+const (
+	tailPageSize = 1000
+	// tailOverlap is number of ids re-read behind the last shown message, so messages of transactions
+	// committed after messages with greater ids are shown too
+	tailOverlap = 500
+)
+
+func tail(ctx context.Context, db *pgxpool.Pool, args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	interval := fs.Duration("interval", time.Second, "polling interval")
+	exchange := fs.String("exchange", "", "show only messages of exchange")
+	eventType := fs.String("event-type", "", "show only messages of event type")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	repo := outbox.NewRepository(outbox.NewPGXAdapter(db))
+	out := outbox.NewWriterPublisher(os.Stdout)
+
+	// messages are paged by monotonic id of outbox table starting after the last stored one,
+	// ids within overlap are read again and messages already shown are skipped
+	var start int64
+	if err := db.QueryRow(ctx, fmt.Sprintf("SELECT COALESCE(max(id), 0) FROM %s", outbox.TableName)).Scan(&start); err != nil {
+		return fmt.Errorf("while reading last message id: %w", err)
+	}
+	cursor := start
+	shown := make(map[int64]struct{})
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		from := cursor - tailOverlap
+		if from < 0 {
+			from = 0
+		}
+
+		records, err := repo.List(ctx, outbox.MessageFilter{
+			Exchange:  *exchange,
+			EventType: *eventType,
+			AfterSeq:  from,
+			Limit:     tailPageSize,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		for _, rec := range records {
+			if _, ok := shown[rec.Seq]; ok || rec.Seq <= start {
+				continue
+			}
+
+			if err = out.Publish(rec.Exchange, rec.RoutingKey, rec.Message); err != nil {
+				return err
+			}
+			shown[rec.Seq] = struct{}{}
+			if rec.Seq > cursor {
+				cursor = rec.Seq
+			}
+		}
+
+		for seq := range shown {
+			if seq <= cursor-tailOverlap {
+				delete(shown, seq)
+			}
+		}
+
+		// full page means more messages are waiting
+		if len(records) == tailPageSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func replay(ctx context.Context, db *pgxpool.Pool, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	ids := fs.String("ids", "", "comma separated message ids")
+	eventType := fs.String("event-type", "", "replay messages of event type")
+	exchange := fs.String("exchange", "", "replay messages of exchange")
+	from := fs.String("from", "", "replay messages created at or after, RFC3339")
+	to := fs.String("to", "", "replay messages created before, RFC3339")
+	copies := fs.Bool("copy", false, "store copies of messages instead of resetting them")
+	dryRun := fs.Bool("dry-run", false, "only count matching messages")
+	batchSize := fs.Int("batch-size", 1000, "number of messages replayed at once")
+	interval := fs.Duration("interval", 0, "pause between batches")
+	ttl := fs.Duration("ttl", 0, "expire replayed messages after, never when zero")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	filter := outbox.ReplayFilter{EventType: *eventType, Exchange: *exchange}
+	if *ids != "" {
+		filter.IDs = strings.Split(*ids, ",")
+	}
+
+	var err error
+	if filter.CreatedAfter, err = parseTime(*from); err != nil {
+		return err
+	}
+	if filter.CreatedBefore, err = parseTime(*to); err != nil {
+		return err
+	}
+	if len(filter.IDs) == 0 && filter.EventType == "" && filter.Exchange == "" &&
+		filter.CreatedAfter.IsZero() && filter.CreatedBefore.IsZero() {
+		return errors.New("at least one filter is required")
+	}
+
+	opts := outbox.ReplayOptions{DryRun: *dryRun, BatchSize: *batchSize, Interval: *interval}
+	if *ttl > 0 {
+		opts.ExpiresAt = time.Now().Add(*ttl)
+	}
+	if *copies {
+		opts.Mode = outbox.ReplayCopy
+	}
+
+	n, err := outbox.NewRepository(outbox.NewPGXAdapter(db)).Replay(ctx, filter, opts)
+	if *dryRun {
+		fmt.Printf("%d messages match\n", n)
+	} else {
+		fmt.Printf("replayed %d messages\n", n)
+	}
+
+	return err
+}
+
+func purge(ctx context.Context, db *pgxpool.Pool, args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ContinueOnError)
+	olderThan := fs.Duration("older-than", 7*24*time.Hour, "delete consumed messages older than")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	n, err := outbox.NewRepository(outbox.NewPGXAdapter(db)).PurgeConsumed(ctx, time.Now().Add(-*olderThan))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("purged %d messages\n", n)
+
+	return nil
+}
+
+func relay(ctx context.Context, db *pgxpool.Pool, args []string) error {
+	fs := flag.NewFlagSet("relay", flag.ContinueOnError)
+	publisherName := fs.String("publisher", "stdout", "publisher: stdout, file or http")
+	file := fs.String("file", "", "file messages are appended to by file publisher")
+	url := fs.String("url", "", "endpoint messages are posted to by http publisher")
+	partitions := fs.Int("partitions", 1, "number of partitions published concurrently")
+	delay := fs.Duration("delay", time.Second, "delay between batches")
+	batchSize := fs.Uint("batch-size", 100, "number of messages fetched per batch")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 30*time.Second, "time to finish in-flight batch on shutdown")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	publisher, closer, err := newPublisher(*publisherName, *file, *url)
+	if err != nil {
+		return err
+	}
+	defer closer.Close() //nolint
+
+	r := outbox.NewRelay(outbox.NewRepository(outbox.NewPGXAdapter(db)), publisher, *partitions, *delay)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- r.Run(context.Background(), outbox.BatchSize(*batchSize))
+	}()
+
+	select {
+	case err = <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	return r.Shutdown(shutdownCtx)
+}
+
+func newPublisher(name, file, url string) (outbox.Publisher, io.Closer, error) {
+	switch name {
+	case "stdout":
+		return outbox.NewWriterPublisher(os.Stdout), io.NopCloser(nil), nil
+	case "file":
+		if file == "" {
+			return nil, nil, errors.New("file is required by file publisher")
+		}
+		f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("while opening file: %w", err)
+		}
+
+		return outbox.NewWriterPublisher(f), f, nil
+	case "http":
+		if url == "" {
+			return nil, nil, errors.New("url is required by http publisher")
+		}
+
+		return outbox.NewHTTPPublisher(url, nil), io.NopCloser(nil), nil
+	default:
+		return nil, nil, fmt.Errorf("unknown publisher %q", name)
+	}
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("while parsing time %q: %w", s, err)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,92 @@
+// Command outbox operates an outbox table: applies schema migrations, inspects,
+// replays and purges messages and runs a standalone relay.
+//
+// Usage:
+//
+//	outbox [-dsn DSN] [-table TABLE] <command> [flags]
+//
+// DSN defaults to OUTBOX_DSN environment variable.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vsvp21/outbox/v5"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, db *pgxpool.Pool, args []string) error
+}
+
+var commands = []command{
+	{name: "migrate", usage: "apply schema migrations", run: migrate},
+	{name: "stats", usage: "show messages per exchange and event type", run: stats},
+	{name: "tail", usage: "print new messages as they are stored", run: tail},
+	{name: "replay", usage: "publish consumed messages again by filter", run: replay},
+	{name: "purge", usage: "delete consumed messages", run: purge},
+	{name: "relay", usage: "run relay with stdout, file or http publisher", run: relay},
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "outbox:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("outbox", flag.ContinueOnError)
+	dsn := fs.String("dsn", os.Getenv("OUTBOX_DSN"), "postgres connection string, OUTBOX_DSN by default")
+	table := fs.String("table", outbox.TableName, "outbox table name")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: outbox [flags] <command> [command flags]\n\nCommands:")
+		for _, c := range commands {
+			fmt.Fprintf(fs.Output(), "  %-8s %s\n", c.name, c.usage)
+		}
+		fmt.Fprintln(fs.Output(), "\nFlags:")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("command is required")
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == fs.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", fs.Arg(0))
+	}
+	if *dsn == "" {
+		return errors.New("dsn is required")
+	}
+	outbox.TableName = *table
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := pgxpool.New(ctx, *dsn)
+	if err != nil {
+		return fmt.Errorf("while connecting to database: %w", err)
+	}
+	defer db.Close()
+
+	return cmd.run(ctx, db, fs.Args()[1:])
+}
@@ -0,0 +1,144 @@
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/hamba/avro/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrUnknownCodec = errors.New("unknown codec")
+
+const (
+	CodecJSON        = "json"
+	CodecProtobuf    = "protobuf"
+	CodecMessagePack = "msgpack"
+	CodecAvro        = "avro"
+)
+
+// Codec encodes message payloads on persist and decodes them after fetch.
+// Codec name is stored with every message, so fetched payload is decoded by the codec it was encoded with.
+type Codec interface {
+	Name() string
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	codecs = map[string]Codec{
+		CodecJSON:        JSONCodec{},
+		CodecProtobuf:    ProtobufCodec{},
+		CodecMessagePack: MessagePackCodec{},
+	}
+	codecsMu sync.RWMutex
+)
+
+// RegisterCodec makes codec available to decode fetched messages by its name,
+// it should be called once on start for every custom codec
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	codecs[c.Name()] = c
+}
+
+// CodecByName returns registered codec, empty name stands for JSON codec
+// which was the only payload format before codecs were introduced
+func CodecByName(name string) (Codec, error) {
+	if name == "" {
+		name = CodecJSON
+	}
+
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, name)
+	}
+
+	return c, nil
+}
+
+// encodePayload encodes payload with codec, string and []byte payloads are considered already encoded
+func encodePayload(c Codec, payload any) ([]byte, error) {
+	switch p := payload.(type) {
+	case []byte:
+		return p, nil
+	case json.RawMessage:
+		return p, nil
+	case string:
+		return []byte(p), nil
+	default:
+		return c.Marshal(payload)
+	}
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string                       { return CodecJSON }
+func (JSONCodec) ContentType() string                { return "application/json" }
+func (JSONCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// ProtobufCodec encodes payloads implementing proto.Message
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) Name() string        { return CodecProtobuf }
+func (ProtobufCodec) ContentType() string { return "application/x-protobuf" }
+
+func (ProtobufCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T does not implement proto.Message", v)
+	}
+
+	return proto.Marshal(m)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec: %T does not implement proto.Message", v)
+	}
+
+	return proto.Unmarshal(data, m)
+}
+
+type MessagePackCodec struct{}
+
+func (MessagePackCodec) Name() string                       { return CodecMessagePack }
+func (MessagePackCodec) ContentType() string                { return "application/msgpack" }
+func (MessagePackCodec) Marshal(v any) ([]byte, error)      { return msgpack.Marshal(v) }
+func (MessagePackCodec) Unmarshal(data []byte, v any) error { return msgpack.Unmarshal(data, v) }
+
+// AvroCodec encodes payloads with an Avro schema. Codecs of different schemas must have different names,
+// since the name stored with a message selects the schema it is decoded with.
+type AvroCodec struct {
+	name   string
+	schema avro.Schema
+}
+
+// NewAvroCodec parses schema of codec, name defaults to "avro"
+func NewAvroCodec(name, schema string) (*AvroCodec, error) {
+	s, err := avro.Parse(schema)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing avro schema: %w", err)
+	}
+	if name == "" {
+		name = CodecAvro
+	}
+
+	return &AvroCodec{name: name, schema: s}, nil
+}
+
+func (c *AvroCodec) Name() string                       { return c.name }
+func (c *AvroCodec) ContentType() string                { return "application/avro" }
+func (c *AvroCodec) Schema() avro.Schema                { return c.schema }
+func (c *AvroCodec) Marshal(v any) ([]byte, error)      { return avro.Marshal(c.schema, v) }
+func (c *AvroCodec) Unmarshal(data []byte, v any) error { return avro.Unmarshal(c.schema, data, v) }
@@ -0,0 +1,66 @@
+package outbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type codecTestEvent struct {
+	Name  string `json:"name" msgpack:"name" avro:"name"`
+	Count int    `json:"count" msgpack:"count" avro:"count"`
+}
+
+func TestCodecs(t *testing.T) {
+	avroCodec, err := NewAvroCodec("avro/test-event", `{
+		"type": "record",
+		"name": "TestEvent",
+		"fields": [{"name": "name", "type": "string"}, {"name": "count", "type": "int"}]
+	}`)
+	require.NoError(t, err)
+	RegisterCodec(avroCodec)
+
+	for _, c := range []Codec{JSONCodec{}, MessagePackCodec{}, avroCodec} {
+		t.Run(c.Name(), func(t *testing.T) {
+			msg := Message{ID: "1", Payload: codecTestEvent{Name: "test", Count: 2}, Codec: c.Name()}
+
+			b, err := msg.BytePayload()
+			require.NoError(t, err)
+
+			fetched := Message{ID: "1", Payload: b, Codec: c.Name()}
+			var decoded codecTestEvent
+			require.NoError(t, fetched.Decode(&decoded))
+
+			assert.Equal(t, codecTestEvent{Name: "test", Count: 2}, decoded)
+			assert.Equal(t, c.ContentType(), fetched.ContentType())
+		})
+	}
+
+	t.Run("protobuf", func(t *testing.T) {
+		msg := Message{ID: "1", Payload: wrapperspb.String("test"), Codec: CodecProtobuf}
+
+		b, err := msg.BytePayload()
+		require.NoError(t, err)
+
+		decoded := &wrapperspb.StringValue{}
+		require.NoError(t, (&Message{Payload: b, Codec: CodecProtobuf}).Decode(decoded))
+		assert.Equal(t, "test", decoded.GetValue())
+
+		_, err = (&Message{Payload: codecTestEvent{}, Codec: CodecProtobuf}).BytePayload()
+		assert.Error(t, err)
+	})
+
+	t.Run("unknown codec", func(t *testing.T) {
+		_, err := (&Message{Payload: codecTestEvent{}, Codec: "unknown"}).BytePayload()
+		assert.ErrorIs(t, err, ErrUnknownCodec)
+		assert.Equal(t, "application/octet-stream", (&Message{Codec: "unknown"}).ContentType())
+	})
+
+	t.Run("encoded payload is kept", func(t *testing.T) {
+		b, err := (&Message{Payload: `{"name":"test"}`}).BytePayload()
+		require.NoError(t, err)
+		assert.Equal(t, `{"name":"test"}`, string(b))
+	})
+}
@@ -0,0 +1,82 @@
+package outbox
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+var ErrUnknownCompression = errors.New("unknown compression")
+
+const (
+	CompressionGzip   = "gzip"
+	CompressionZstd   = "zstd"
+	CompressionSnappy = "snappy"
+
+	// HeaderContentEncoding is set to compression of payloads fetched without decompression
+	HeaderContentEncoding = "content-encoding"
+)
+
+var (
+	zstdEncoder     *zstd.Encoder
+	zstdDecoder     *zstd.Decoder
+	zstdEncoderOnce sync.Once
+	zstdDecoderOnce sync.Once
+)
+
+func compress(algorithm string, data []byte) ([]byte, error) {
+	switch algorithm {
+	case CompressionGzip:
+		buf := &bytes.Buffer{}
+		w := gzip.NewWriter(buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		zstdEncoderOnce.Do(func() {
+			zstdEncoder, _ = zstd.NewWriter(nil)
+		})
+
+		return zstdEncoder.EncodeAll(data, nil), nil
+	case CompressionSnappy:
+		return s2.EncodeSnappy(nil, data), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCompression, algorithm)
+	}
+}
+
+func decompress(algorithm string, data []byte) ([]byte, error) {
+	switch algorithm {
+	case "":
+		return data, nil
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close() //nolint
+
+		return io.ReadAll(r)
+	case CompressionZstd:
+		zstdDecoderOnce.Do(func() {
+			zstdDecoder, _ = zstd.NewReader(nil)
+		})
+
+		return zstdDecoder.DecodeAll(data, nil)
+	case CompressionSnappy:
+		return s2.Decode(nil, data)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCompression, algorithm)
+	}
+}
@@ -0,0 +1,56 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompression(t *testing.T) {
+	payload := map[string]string{"document": strings.Repeat("outbox ", 100)}
+
+	for _, algorithm := range []string{CompressionGzip, CompressionZstd, CompressionSnappy} {
+		t.Run(algorithm, func(t *testing.T) {
+			cfg := newPersisterConfig([]PersisterOption{WithCompression(algorithm, 100)})
+
+			msg := Message{ID: "1", Payload: payload}
+			require.NoError(t, cfg.prepare(context.Background(), &msg))
+			assert.Equal(t, algorithm, msg.Compression)
+			assert.Less(t, len(msg.Payload.([]byte)), 700)
+
+			var decoded map[string]string
+			require.NoError(t, msg.Decode(&decoded))
+			assert.Equal(t, payload, decoded)
+
+			fetched := msg
+			require.NoError(t, (&Repository{}).decode(context.Background(), &fetched))
+			assert.Empty(t, fetched.Compression)
+			assert.JSONEq(t, `{"document": "`+payload["document"]+`"}`, string(fetched.Payload.(json.RawMessage)))
+
+			forwarded := msg
+			require.NoError(t, (&Repository{keepCompressed: true}).decode(context.Background(), &forwarded))
+			assert.Equal(t, algorithm, forwarded.Headers[HeaderContentEncoding])
+			assert.Equal(t, msg.Payload, forwarded.Payload)
+		})
+	}
+
+	t.Run("Test payloads below threshold are not compressed", func(t *testing.T) {
+		cfg := newPersisterConfig([]PersisterOption{WithCompression(CompressionGzip, 1024)})
+
+		msg := Message{ID: "1", Payload: map[string]string{"a": "b"}}
+		require.NoError(t, cfg.prepare(context.Background(), &msg))
+		assert.Empty(t, msg.Compression)
+		assert.Equal(t, `{"a":"b"}`, string(msg.Payload.([]byte)))
+	})
+
+	t.Run("Test unknown compression", func(t *testing.T) {
+		cfg := newPersisterConfig([]PersisterOption{WithCompression("lz4", 0)})
+
+		msg := Message{ID: "1", Payload: map[string]string{"a": "b"}}
+		assert.ErrorIs(t, cfg.prepare(context.Background(), &msg), ErrUnknownCompression)
+	})
+}
@@ -38,6 +38,19 @@ func (r PGXRowsWrapper) Close() error {
 	return nil
 }
 
+func (r PGXRowsWrapper) Err() error {
+	return r.rows.Err()
+}
+
+// rowsErr returns error encountered during iteration when rows are able to report it
+func rowsErr(rows Rows) error {
+	if r, ok := rows.(interface{ Err() error }); ok {
+		return r.Err()
+	}
+
+	return nil
+}
+
 type PGXAdapter struct {
 	conn *pgxpool.Pool
 }
@@ -56,7 +69,7 @@ func (a *PGXAdapter) Query(ctx context.Context, query string, args ...any) (Rows
 }
 
 func (a *PGXAdapter) Exec(ctx context.Context, query string, args ...any) error {
-	_, err := a.conn.Exec(ctx, query, args)
+	_, err := a.conn.Exec(ctx, query, args...)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,251 @@
+package outbox
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrUnknownKey        = errors.New("unknown encryption key")
+	ErrNoKeyProvider     = errors.New("no key provider to decrypt payload")
+	ErrKeyStillCurrent   = errors.New("encryption key is still current")
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+)
+
+const (
+	dataKeySize      = 32
+	keyRotationBatch = 100
+)
+
+// KeyProvider wraps data keys payloads are encrypted with, usually by a key management service.
+// Keys are identified by ids stored with messages, so keys are rotated by wrapping new data keys
+// with a new key while older keys are still available to unwrap stored ones.
+type KeyProvider interface {
+	// WrapKey encrypts data key with current key and returns its id
+	WrapKey(ctx context.Context, dataKey []byte) (keyID string, wrapped []byte, err error)
+	// UnwrapKey decrypts data key wrapped with key of keyID
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// LocalKeyProvider wraps data keys with AES-GCM keys kept in memory
+type LocalKeyProvider struct {
+	keys    map[string][]byte
+	current string
+	mu      sync.RWMutex
+}
+
+// NewLocalKeyProvider creates provider wrapping data keys with 16, 24 or 32 bytes key of keyID
+func NewLocalKeyProvider(keyID string, key []byte) (*LocalKeyProvider, error) {
+	p := &LocalKeyProvider{keys: make(map[string][]byte)}
+	if err := p.Rotate(keyID, key); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Rotate makes key of keyID current, previous keys are kept to unwrap stored data keys
+func (p *LocalKeyProvider) Rotate(keyID string, key []byte) error {
+	if _, err := aes.NewCipher(key); err != nil {
+		return fmt.Errorf("invalid key %s: %w", keyID, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.keys[keyID] = key
+	p.current = keyID
+
+	return nil
+}
+
+func (p *LocalKeyProvider) WrapKey(_ context.Context, dataKey []byte) (string, []byte, error) {
+	p.mu.RLock()
+	keyID, key := p.current, p.keys[p.current]
+	p.mu.RUnlock()
+
+	wrapped, err := seal(key, dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return keyID, wrapped, nil
+}
+
+func (p *LocalKeyProvider) UnwrapKey(_ context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	p.mu.RLock()
+	key, ok := p.keys[keyID]
+	p.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+
+	return open(key, wrapped)
+}
+
+// encryptPayload encrypts payload with new data key wrapped by provider
+func encryptPayload(ctx context.Context, provider KeyProvider, msg *Message, payload []byte) error {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return fmt.Errorf("while generating data key: %w", err)
+	}
+
+	keyID, wrapped, err := provider.WrapKey(ctx, dataKey)
+	if err != nil {
+		return fmt.Errorf("while wrapping data key: %w", err)
+	}
+
+	ciphertext, err := seal(dataKey, payload)
+	if err != nil {
+		return err
+	}
+
+	msg.Payload = ciphertext
+	msg.keyID = keyID
+	msg.dataKey = wrapped
+
+	return nil
+}
+
+// decryptPayload replaces encrypted payload of message with plaintext
+func decryptPayload(ctx context.Context, provider KeyProvider, msg *Message) error {
+	if msg.keyID == "" {
+		return nil
+	}
+	if provider == nil {
+		return fmt.Errorf("%w: key %s", ErrNoKeyProvider, msg.keyID)
+	}
+
+	dataKey, err := provider.UnwrapKey(ctx, msg.keyID, msg.dataKey)
+	if err != nil {
+		return fmt.Errorf("while unwrapping data key: %w", err)
+	}
+
+	ciphertext, err := msg.BytePayload()
+	if err != nil {
+		return err
+	}
+
+	payload, err := open(dataKey, ciphertext)
+	if err != nil {
+		return err
+	}
+
+	msg.Payload = payload
+	msg.keyID = ""
+	msg.dataKey = nil
+
+	return nil
+}
+
+// seal encrypts plaintext with AES-GCM, random nonce is prepended to ciphertext
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("while generating nonce: %w", err)
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+
+	plaintext, err := gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCiphertext, err)
+	}
+
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("while creating cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// RotateKey wraps data keys of messages encrypted with key of keyID with current key of provider.
+// Payloads are not encrypted again, so rotation is cheap. It returns number of rotated messages.
+func (r *Repository) RotateKey(ctx context.Context, keyID string) (int64, error) {
+	if r.keys == nil {
+		return 0, ErrNoKeyProvider
+	}
+
+	// key_id <> '' matches partial index of encrypted messages
+	selectQuery := fmt.Sprintf("SELECT event_id, data_key FROM %s WHERE key_id = $1 AND key_id <> '' LIMIT $2", TableName)
+	updateQuery := fmt.Sprintf("UPDATE %s SET key_id = $1, data_key = $2 WHERE event_id = $3 AND key_id = $4", TableName)
+
+	var total int64
+	for {
+		keys, err := r.wrappedKeys(ctx, selectQuery, keyID)
+		if err != nil {
+			return total, err
+		}
+		if len(keys) == 0 {
+			return total, nil
+		}
+
+		for id, wrapped := range keys {
+			dataKey, err := r.keys.UnwrapKey(ctx, keyID, wrapped)
+			if err != nil {
+				return total, fmt.Errorf("while unwrapping data key of message %s: %w", id, err)
+			}
+
+			newKeyID, rewrapped, err := r.keys.WrapKey(ctx, dataKey)
+			if err != nil {
+				return total, fmt.Errorf("while wrapping data key of message %s: %w", id, err)
+			}
+			if newKeyID == keyID {
+				return total, fmt.Errorf("%w: %s", ErrKeyStillCurrent, keyID)
+			}
+
+			if err = r.db.Exec(ctx, updateQuery, newKeyID, rewrapped, id, keyID); err != nil {
+				return total, fmt.Errorf("while updating data key of message %s: %w", id, err)
+			}
+			total++
+		}
+	}
+}
+
+func (r *Repository) wrappedKeys(ctx context.Context, query, keyID string) (map[string][]byte, error) {
+	rows, err := r.db.Query(ctx, query, keyID, keyRotationBatch)
+	if err != nil {
+		return nil, fmt.Errorf("while quering data keys: %w", err)
+	}
+	defer rows.Close() //nolint
+
+	keys := make(map[string][]byte)
+	for rows.Next() {
+		var (
+			id      string
+			wrapped []byte
+		)
+		if err = rows.Scan(&id, &wrapped); err != nil {
+			return nil, fmt.Errorf("while scan data keys: %w", err)
+		}
+		keys[id] = wrapped
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,58 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryption(t *testing.T) {
+	ctx := context.Background()
+	keys, err := NewLocalKeyProvider("key-1", bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+
+	cfg := newPersisterConfig([]PersisterOption{WithCompression(CompressionGzip, 0), WithEncryption(keys)})
+
+	msg := Message{ID: "1", Payload: map[string]string{"email": "user@example.com"}}
+	require.NoError(t, cfg.prepare(ctx, &msg))
+	assert.Equal(t, "key-1", msg.keyID)
+	assert.NotContains(t, string(msg.Payload.([]byte)), "user@example.com")
+
+	t.Run("Test fetched payload is decrypted", func(t *testing.T) {
+		fetched := msg
+		require.NoError(t, (&Repository{keys: keys}).decode(ctx, &fetched))
+		assert.Empty(t, fetched.keyID)
+		assert.Equal(t, `{"email":"user@example.com"}`, string(fetched.Payload.(json.RawMessage)))
+	})
+
+	t.Run("Test payloads of rotated keys are decrypted", func(t *testing.T) {
+		require.NoError(t, keys.Rotate("key-2", bytes.Repeat([]byte{2}, 32)))
+
+		rotated := Message{ID: "2", Payload: `{"email":"other@example.com"}`}
+		require.NoError(t, cfg.prepare(ctx, &rotated))
+		assert.Equal(t, "key-2", rotated.keyID)
+
+		for _, m := range []Message{msg, rotated} {
+			require.NoError(t, (&Repository{keys: keys}).decode(ctx, &m))
+			assert.Contains(t, string(m.Payload.(json.RawMessage)), "@example.com")
+		}
+	})
+
+	t.Run("Test decryption errors", func(t *testing.T) {
+		fetched := msg
+		assert.ErrorIs(t, (&Repository{}).decode(ctx, &fetched), ErrNoKeyProvider)
+
+		other, err := NewLocalKeyProvider("key-3", bytes.Repeat([]byte{3}, 32))
+		require.NoError(t, err)
+		assert.ErrorIs(t, (&Repository{keys: other}).decode(ctx, &fetched), ErrUnknownKey)
+
+		tampered := msg
+		tampered.Payload = append([]byte{}, msg.Payload.([]byte)...)
+		tampered.Payload.([]byte)[20] ^= 1
+		assert.ErrorIs(t, (&Repository{keys: keys}).decode(ctx, &tampered), ErrInvalidCiphertext)
+	})
+}
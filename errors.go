@@ -0,0 +1,73 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+)
+
+// FetchErrorReporter is implemented by repositories able to report fetch failures to the relay.
+// Errors channel is closed once fetching is finished.
+type FetchErrorReporter interface {
+	FetchWithErrors(ctx context.Context, batchSize BatchSize) (<-chan Message, <-chan error)
+}
+
+// ErrorPolicy reports whether relay error is fatal, fatal errors stop Run and are returned from it
+type ErrorPolicy func(err error) bool
+
+// ErrorHandler receives transient relay errors
+type ErrorHandler func(ctx context.Context, err error)
+
+// FetchError is a failure of fetching messages
+type FetchError struct {
+	Err error
+}
+
+func (e *FetchError) Error() string {
+	return fmt.Sprintf("fetch messages: %s", e.Err)
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// PublishError is a failure of publishing a message
+type PublishError struct {
+	Message Message
+	Err     error
+}
+
+func (e *PublishError) Error() string {
+	return fmt.Sprintf("publish message %s: %s", e.Message.ID, e.Err)
+}
+
+func (e *PublishError) Unwrap() error {
+	return e.Err
+}
+
+// MarkConsumedError is a failure of marking published messages consumed
+type MarkConsumedError struct {
+	Messages []Message
+	Err      error
+}
+
+func (e *MarkConsumedError) Error() string {
+	return fmt.Sprintf("mark %d messages consumed: %s", len(e.Messages), e.Err)
+}
+
+func (e *MarkConsumedError) Unwrap() error {
+	return e.Err
+}
+
+// MarkExpiredError is a failure of marking expired messages
+type MarkExpiredError struct {
+	Messages []Message
+	Err      error
+}
+
+func (e *MarkExpiredError) Error() string {
+	return fmt.Sprintf("mark %d messages expired: %s", len(e.Messages), e.Err)
+}
+
+func (e *MarkExpiredError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,128 @@
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var (
+	ErrUnknownEventType = errors.New("unknown event type")
+	ErrInvalidPayload   = errors.New("invalid payload")
+)
+
+// NewEvent creates a message of eventType, T must be the payload type registered for eventType
+func NewEvent[T any](r *EventRegistry, id, eventType string, payload T, exchange, partition, routingKey string) (Message, error) {
+	t, err := r.Type(eventType)
+	if err != nil {
+		return Message{}, err
+	}
+
+	if err = checkPayloadType(eventType, t, reflect.TypeOf((*T)(nil)).Elem()); err != nil {
+		return Message{}, err
+	}
+
+	return NewMessage(id, eventType, payload, exchange, partition, routingKey), nil
+}
+
+// DecodeEvent decodes message payload into T
+func DecodeEvent[T any](m Message) (T, error) {
+	var v T
+	if p, ok := m.Payload.(T); ok {
+		return p, nil
+	}
+
+	if err := m.Decode(&v); err != nil {
+		return v, err
+	}
+
+	return v, nil
+}
+
+// EventRegistry maps event types to Go types of their payloads
+type EventRegistry struct {
+	types map[string]reflect.Type
+	mu    sync.RWMutex
+}
+
+func NewEventRegistry() *EventRegistry {
+	return &EventRegistry{types: make(map[string]reflect.Type)}
+}
+
+// RegisterEvent registers T as payload type of eventType
+func RegisterEvent[T any](r *EventRegistry, eventType string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.types[eventType] = reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// Type returns payload type registered for eventType
+func (r *EventRegistry) Type(eventType string) (reflect.Type, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.types[eventType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownEventType, eventType)
+	}
+
+	return t, nil
+}
+
+// Decode decodes message payload into its registered type and returns pointer to it
+func (r *EventRegistry) Decode(m Message) (any, error) {
+	t, err := r.Type(m.EventType)
+	if err != nil {
+		return nil, err
+	}
+
+	v := reflect.New(t)
+	if err = m.Decode(v.Interface()); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPayload, err)
+	}
+
+	return v.Interface(), nil
+}
+
+// Validate checks that event type of message is registered and payload is of registered type.
+// Encoded payloads are checked to be decodable into registered type with codec,
+// codec of the message is used when codec is nil.
+func (r *EventRegistry) Validate(m Message, codec Codec) error {
+	t, err := r.Type(m.EventType)
+	if err != nil {
+		return err
+	}
+
+	switch m.Payload.(type) {
+	case []byte, json.RawMessage, string:
+		if codec == nil {
+			if codec, err = CodecByName(m.Codec); err != nil {
+				return err
+			}
+		}
+
+		b, err := encodePayload(codec, m.Payload)
+		if err != nil {
+			return err
+		}
+
+		if err = codec.Unmarshal(b, reflect.New(t).Interface()); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidPayload, err)
+		}
+
+		return nil
+	}
+
+	return checkPayloadType(m.EventType, t, reflect.TypeOf(m.Payload))
+}
+
+func checkPayloadType(eventType string, t, pt reflect.Type) error {
+	if pt != t && pt != reflect.PointerTo(t) {
+		return fmt.Errorf("%w: %s payload must be %s, got %v", ErrInvalidPayload, eventType, t, pt)
+	}
+
+	return nil
+}
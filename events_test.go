@@ -0,0 +1,83 @@
+package outbox
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type orderCreated struct {
+	OrderID string `json:"order_id"`
+}
+
+type orderCancelled struct {
+	Reason string `json:"reason"`
+}
+
+func TestEventRegistry(t *testing.T) {
+	r := NewEventRegistry()
+	RegisterEvent[orderCreated](r, "OrderCreated")
+	RegisterEvent[orderCancelled](r, "OrderCancelled")
+
+	t.Run("Test validate", func(t *testing.T) {
+		assert.NoError(t, r.Validate(NewMessage("1", "OrderCreated", orderCreated{OrderID: "1"}, "orders", "1", "created"), nil))
+		assert.NoError(t, r.Validate(NewMessage("1", "OrderCreated", &orderCreated{OrderID: "1"}, "orders", "1", "created"), nil))
+		assert.NoError(t, r.Validate(NewMessage("1", "OrderCreated", []byte(`{"order_id":"1"}`), "orders", "1", "created"), nil))
+
+		assert.ErrorIs(t, r.Validate(NewMessage("1", "OrderDeleted", orderCreated{}, "orders", "1", "created"), nil), ErrUnknownEventType)
+		assert.ErrorIs(t, r.Validate(NewMessage("1", "OrderCreated", orderCancelled{}, "orders", "1", "created"), nil), ErrInvalidPayload)
+		assert.ErrorIs(t, r.Validate(NewMessage("1", "OrderCreated", "not json", "orders", "1", "created"), nil), ErrInvalidPayload)
+	})
+
+	t.Run("Test encoded payloads are validated with persister codec", func(t *testing.T) {
+		payload, err := MessagePackCodec{}.Marshal(orderCreated{OrderID: "1"})
+		require.NoError(t, err)
+
+		cfg := newPersisterConfig([]PersisterOption{WithEventRegistry(r), WithCodec(MessagePackCodec{})})
+		msg := NewMessage("1", "OrderCreated", payload, "orders", "1", "created")
+		require.NoError(t, cfg.prepare(context.Background(), &msg))
+		assert.Equal(t, CodecMessagePack, msg.Codec)
+	})
+
+	t.Run("Test new event enforces registered type", func(t *testing.T) {
+		msg, err := NewEvent(r, "1", "OrderCreated", orderCreated{OrderID: "1"}, "orders", "1", "created")
+		require.NoError(t, err)
+		assert.Equal(t, orderCreated{OrderID: "1"}, msg.Payload)
+
+		_, err = NewEvent(r, "1", "OrderCreated", &orderCreated{OrderID: "1"}, "orders", "1", "created")
+		assert.NoError(t, err)
+
+		_, err = NewEvent(r, "1", "OrderCreated", orderCancelled{}, "orders", "1", "created")
+		assert.ErrorIs(t, err, ErrInvalidPayload)
+
+		_, err = NewEvent(r, "1", "OrderDeleted", orderCreated{}, "orders", "1", "created")
+		assert.ErrorIs(t, err, ErrUnknownEventType)
+	})
+
+	t.Run("Test decode fetched message", func(t *testing.T) {
+		fetched := Message{ID: "1", EventType: "OrderCancelled", Payload: []byte(`{"reason":"test"}`), Codec: CodecJSON}
+
+		v, err := r.Decode(fetched)
+		require.NoError(t, err)
+		assert.Equal(t, &orderCancelled{Reason: "test"}, v)
+
+		event, err := DecodeEvent[orderCancelled](fetched)
+		require.NoError(t, err)
+		assert.Equal(t, orderCancelled{Reason: "test"}, event)
+
+		_, err = r.Decode(Message{EventType: "Unknown", Payload: []byte(`{}`)})
+		assert.ErrorIs(t, err, ErrUnknownEventType)
+	})
+
+	t.Run("Test persister rejects unknown event types", func(t *testing.T) {
+		cfg := newPersisterConfig([]PersisterOption{WithEventRegistry(r)})
+
+		msg := NewMessage("1", "OrderCreated", orderCreated{OrderID: "1"}, "orders", "1", "created")
+		require.NoError(t, cfg.prepare(context.Background(), &msg))
+
+		msg = NewMessage("2", "OrderDeleted", orderCreated{OrderID: "1"}, "orders", "1", "created")
+		assert.ErrorIs(t, cfg.prepare(context.Background(), &msg), ErrUnknownEventType)
+	})
+}
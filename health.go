@@ -0,0 +1,220 @@
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Health is a snapshot of relay state.
+// Backlog is collected after every batch when relay has metrics or health checks check backlog age.
+type Health struct {
+	Running             bool         `json:"running"`
+	LastIteration       time.Time    `json:"last_iteration"`
+	LastFetch           time.Time    `json:"last_fetch"`
+	LastPublish         time.Time    `json:"last_publish"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	Backlog             BacklogStats `json:"backlog"`
+	CircuitState        CircuitState `json:"circuit_state"`
+}
+
+// relayHealth tracks relay state reported by Relay.Health
+type relayHealth struct {
+	running             bool
+	lastIteration       time.Time
+	lastFetch           time.Time
+	lastPublish         time.Time
+	consecutiveFailures int
+	batchErrors         int
+	backlog             BacklogStats
+	mu                  sync.Mutex
+}
+
+func (h *relayHealth) setRunning(running bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.running = running
+}
+
+func (h *relayHealth) iterated() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastIteration = time.Now()
+}
+
+func (h *relayHealth) fetched() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastFetch = time.Now()
+}
+
+func (h *relayHealth) published() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastPublish = time.Now()
+}
+
+func (h *relayHealth) failed() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.batchErrors++
+}
+
+func (h *relayHealth) backlogReported(stats BacklogStats) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.backlog = stats
+}
+
+// batchStarted returns function to call when batch is finished,
+// it counts consecutive batches with failures
+func (h *relayHealth) batchStarted() func() {
+	h.mu.Lock()
+	errorsBefore := h.batchErrors
+	h.mu.Unlock()
+
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if h.batchErrors > errorsBefore {
+			h.consecutiveFailures++
+			return
+		}
+		h.consecutiveFailures = 0
+	}
+}
+
+func (h *relayHealth) snapshot() Health {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return Health{
+		Running:             h.running,
+		LastIteration:       h.lastIteration,
+		LastFetch:           h.lastFetch,
+		LastPublish:         h.lastPublish,
+		ConsecutiveFailures: h.consecutiveFailures,
+		Backlog:             h.backlog,
+	}
+}
+
+// Health returns current relay state
+func (r *Relay) Health() Health {
+	h := r.health.snapshot()
+	if r.breaker != nil {
+		h.CircuitState = r.breaker.State()
+	}
+
+	return h
+}
+
+var (
+	ErrRelayNotRunning = errors.New("relay is not running")
+	ErrRelayStuck      = errors.New("relay is stuck")
+	ErrRelayNotReady   = errors.New("relay is not ready")
+)
+
+// HealthCheckOptions configures liveness and readiness of relay
+type HealthCheckOptions struct {
+	// MaxIterationAge is the longest time relay may spend on a single iteration before considered stuck,
+	// defaults to twice the sum of publish delay, batch timeout and mark consumed timeout
+	MaxIterationAge time.Duration
+	// MaxConsecutiveFailures is the number of failed batches in a row relay is still ready with, 0 disables the check
+	MaxConsecutiveFailures int
+	// MaxBacklogAge is the oldest unconsumed message age relay is still ready with, 0 disables the check
+	MaxBacklogAge time.Duration
+}
+
+// Live returns error when relay is not running or stuck
+func (r *Relay) Live(opts HealthCheckOptions) error {
+	h := r.Health()
+	if !h.Running {
+		return ErrRelayNotRunning
+	}
+
+	maxAge := opts.MaxIterationAge
+	if maxAge == 0 {
+		maxAge = 2 * (r.delay + r.batchTimeout + markConsumedTimeout)
+	}
+
+	if !h.LastIteration.IsZero() && time.Since(h.LastIteration) > maxAge {
+		return fmt.Errorf("%w: last iteration %s ago", ErrRelayStuck, time.Since(h.LastIteration))
+	}
+
+	return nil
+}
+
+// Ready returns error when relay is not live, its circuit breaker is open,
+// it failed too many batches in a row or backlog is too old
+func (r *Relay) Ready(opts HealthCheckOptions) error {
+	r.checkBacklog(opts)
+
+	if err := r.Live(opts); err != nil {
+		return err
+	}
+
+	h := r.Health()
+	if h.CircuitState == CircuitOpen {
+		return fmt.Errorf("%w: circuit breaker is open", ErrRelayNotReady)
+	}
+
+	if opts.MaxConsecutiveFailures > 0 && h.ConsecutiveFailures > opts.MaxConsecutiveFailures {
+		return fmt.Errorf("%w: %d consecutive failed batches", ErrRelayNotReady, h.ConsecutiveFailures)
+	}
+
+	if age := h.Backlog.OldestAge(time.Now()); opts.MaxBacklogAge > 0 && age > opts.MaxBacklogAge {
+		return fmt.Errorf("%w: oldest unconsumed message is %s old", ErrRelayNotReady, age)
+	}
+
+	return nil
+}
+
+// NewHealthHandler returns handler serving relay liveness on /livez and readiness on /readyz.
+// Responses contain relay Health, status is 503 when check fails.
+func NewHealthHandler(r *Relay, opts HealthCheckOptions) http.Handler {
+	r.checkBacklog(opts)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, _ *http.Request) {
+		writeHealth(w, r.Health(), r.Live(opts))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		writeHealth(w, r.Health(), r.Ready(opts))
+	})
+
+	return mux
+}
+
+// checkBacklog makes relay collect backlog when opts check its age
+func (r *Relay) checkBacklog(opts HealthCheckOptions) {
+	if opts.MaxBacklogAge > 0 {
+		r.healthBacklog.Store(true)
+	}
+}
+
+func writeHealth(w http.ResponseWriter, h Health, checkErr error) {
+	resp := struct {
+		Health
+		Error string `json:"error,omitempty"`
+	}{Health: h}
+
+	status := http.StatusOK
+	if checkErr != nil {
+		status = http.StatusServiceUnavailable
+		resp.Error = checkErr.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,97 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// backlogRepositoryMock reports backlog with an old message
+type backlogRepositoryMock struct {
+	RepositoryMock
+	calls int
+}
+
+func (m *backlogRepositoryMock) Backlog(ctx context.Context) (BacklogStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls++
+
+	return BacklogStats{Size: 1, OldestDueAt: time.Now().Add(-time.Hour)}, nil
+}
+
+func TestRelay_Health(t *testing.T) {
+	t.Run("Test backlog is collected only when checked", func(t *testing.T) {
+		r := &backlogRepositoryMock{}
+		relay := NewRelay(r, &PublisherMock{}, 1, time.Millisecond, WithLogger(NopLogger{}))
+
+		relay.processBatch(context.Background(), BatchSize(10))
+		assert.Equal(t, 0, r.calls)
+
+		opts := HealthCheckOptions{MaxBacklogAge: time.Minute}
+		NewHealthHandler(relay, opts)
+		relay.processBatch(context.Background(), BatchSize(10))
+		assert.Equal(t, 1, r.calls)
+
+		relay.health.setRunning(true)
+		assert.ErrorIs(t, relay.Ready(opts), ErrRelayNotReady)
+	})
+
+	t.Run("Test health of running relay", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		r := &RepositoryMock{Messages: GenerateMessages(5)}
+		relay := NewRelay(r, &PublisherMock{}, 1, time.Millisecond, WithLogger(NopLogger{}))
+
+		assert.ErrorIs(t, relay.Live(HealthCheckOptions{}), ErrRelayNotRunning)
+
+		go relay.Run(ctx, BatchSize(10)) //nolint
+		assert.Eventually(t, func() bool { return !relay.Health().LastPublish.IsZero() }, time.Second, time.Millisecond)
+
+		h := relay.Health()
+		assert.True(t, h.Running)
+		assert.False(t, h.LastFetch.IsZero())
+		assert.Equal(t, 0, h.ConsecutiveFailures)
+		assert.NoError(t, relay.Ready(HealthCheckOptions{MaxConsecutiveFailures: 1}))
+	})
+
+	t.Run("Test relay with open circuit is not ready", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		p := PublisherFunc(func(exchange, topic string, message Message) error {
+			return errors.New("broker down")
+		})
+		r := &RepositoryMock{Messages: GenerateMessages(1)}
+		relay := NewRelay(r, p, 1, time.Millisecond,
+			WithCircuitBreaker(NewCircuitBreaker(1, time.Hour, 1)), WithPublisherMiddlewares(), WithLogger(NopLogger{}))
+
+		go relay.Run(ctx, BatchSize(10)) //nolint
+		assert.Eventually(t, func() bool { return relay.Health().CircuitState == CircuitOpen }, time.Second, time.Millisecond)
+
+		assert.NoError(t, relay.Live(HealthCheckOptions{}))
+		assert.ErrorIs(t, relay.Ready(HealthCheckOptions{}), ErrRelayNotReady)
+		assert.Equal(t, 1, relay.Health().ConsecutiveFailures)
+
+		rec := httptest.NewRecorder()
+		NewHealthHandler(relay, HealthCheckOptions{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+		resp := map[string]any{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, "open", resp["circuit_state"])
+		assert.Contains(t, resp["error"], "circuit breaker is open")
+
+		rec = httptest.NewRecorder()
+		NewHealthHandler(relay, HealthCheckOptions{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+}
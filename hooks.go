@@ -0,0 +1,73 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrSkipMessage returned from Hooks.BeforePublish marks message consumed without publishing it
+	ErrSkipMessage = errors.New("skip message")
+	// ErrVetoed is returned by relay for messages vetoed by Hooks.BeforePublish,
+	// they are neither published nor counted as failures and are retried with the next batch
+	ErrVetoed = errors.New("message publishing vetoed")
+)
+
+// Hooks are invoked by relay on pipeline stages, nil hooks are ignored.
+// Hooks are called concurrently from partition workers and must be safe for concurrent use.
+type Hooks struct {
+	// OnBatchFetched is called with all messages of a batch once fetching is finished
+	OnBatchFetched func(ctx context.Context, msgs []Message)
+	// BeforePublish is called before publishing and may mutate message.
+	// Returning ErrSkipMessage marks message consumed without publishing it,
+	// any other error vetoes publishing, message is skipped without counting a failure
+	// and is retried with the next batch.
+	BeforePublish func(ctx context.Context, msg *Message) error
+	// AfterPublish is called with result of publishing
+	AfterPublish func(ctx context.Context, msg Message, err error)
+	// OnMarkConsumedError is called when published messages failed to be marked consumed
+	// and when expired messages failed to be marked expired
+	OnMarkConsumedError func(ctx context.Context, msgs []Message, err error)
+	// OnDrop is called when message is dropped because its partition channel is full
+	OnDrop func(ctx context.Context, msg Message, partition int)
+	// OnCircuitStateChange is called when circuit breaker of relay changes state
+	OnCircuitStateChange func(from, to CircuitState)
+}
+
+func (h Hooks) batchFetched(ctx context.Context, msgs []Message) {
+	if h.OnBatchFetched != nil {
+		h.OnBatchFetched(ctx, msgs)
+	}
+}
+
+func (h Hooks) beforePublish(ctx context.Context, msg *Message) error {
+	if h.BeforePublish != nil {
+		return h.BeforePublish(ctx, msg)
+	}
+
+	return nil
+}
+
+func (h Hooks) afterPublish(ctx context.Context, msg Message, err error) {
+	if h.AfterPublish != nil {
+		h.AfterPublish(ctx, msg, err)
+	}
+}
+
+func (h Hooks) markConsumedError(ctx context.Context, msgs []Message, err error) {
+	if h.OnMarkConsumedError != nil {
+		h.OnMarkConsumedError(ctx, msgs, err)
+	}
+}
+
+func (h Hooks) drop(ctx context.Context, msg Message, partition int) {
+	if h.OnDrop != nil {
+		h.OnDrop(ctx, msg, partition)
+	}
+}
+
+func (h Hooks) circuitStateChange(from, to CircuitState) {
+	if h.OnCircuitStateChange != nil {
+		h.OnCircuitStateChange(from, to)
+	}
+}
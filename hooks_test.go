@@ -0,0 +1,73 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelay_Hooks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Millisecond*500)
+	defer cancel()
+
+	msgs := GenerateMessages(3)
+	vetoed, skipped := msgs[0].ID, msgs[2].ID
+
+	var (
+		fetched   []Message
+		published []string
+		mu        sync.Mutex
+	)
+
+	hooks := Hooks{
+		OnBatchFetched: func(ctx context.Context, msgs []Message) {
+			mu.Lock()
+			fetched = append(fetched, msgs...)
+			mu.Unlock()
+		},
+		BeforePublish: func(ctx context.Context, msg *Message) error {
+			switch msg.ID {
+			case skipped:
+				return ErrSkipMessage
+			case vetoed:
+				return errors.New("vetoed")
+			}
+			msg.RoutingKey = "mutated"
+
+			return nil
+		},
+		AfterPublish: func(ctx context.Context, msg Message, err error) {
+			mu.Lock()
+			published = append(published, msg.ID)
+			mu.Unlock()
+		},
+	}
+
+	r := &RepositoryMock{Messages: msgs}
+	p := &PublisherMock{}
+
+	var errs []error
+	handler := func(ctx context.Context, err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+
+	// vetoed message is the first one of the only partition, so following messages are published only
+	// when veto does not stop the partition worker
+	relay := NewRelay(r, p, 1, time.Millisecond, WithHooks(hooks), WithErrorHandler(handler), WithLogger(NopLogger{}))
+	assert.NoError(t, relay.Run(ctx, BatchSize(10)))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, fetched, 3)
+	assert.Equal(t, []string{msgs[1].ID}, published)
+	assert.Len(t, p.Published, 1)
+	assert.Equal(t, "mutated", p.Published[0].RoutingKey)
+	assert.ElementsMatch(t, []string{msgs[1].ID, skipped}, r.Consumed)
+	assert.Empty(t, errs)
+}
@@ -0,0 +1,48 @@
+package outbox
+
+import (
+	"fmt"
+	"sync"
+)
+
+// HandlerFunc handles a message dispatched by InProcessPublisher
+type HandlerFunc func(message Message) error
+
+// InProcessPublisher dispatches messages to handlers registered in the same process
+// keyed by message EventType. It lets modules of a monolith communicate through the
+// outbox and later be switched to a real broker by replacing the publisher.
+//
+// Handler errors are returned from Publish, so the relay retries the message.
+// Since every handler of an event type is invoked again on retry, handlers must be idempotent.
+type InProcessPublisher struct {
+	handlers map[string][]HandlerFunc
+	mu       sync.RWMutex
+}
+
+func NewInProcessPublisher() *InProcessPublisher {
+	return &InProcessPublisher{handlers: make(map[string][]HandlerFunc)}
+}
+
+// Register registers handler for messages of eventType
+func (p *InProcessPublisher) Register(eventType string, handler HandlerFunc) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.handlers[eventType] = append(p.handlers[eventType], handler)
+}
+
+// Publish invokes handlers registered for message event type in order of registration.
+// Messages without handlers are acknowledged, as a broker would do.
+func (p *InProcessPublisher) Publish(exchange, topic string, message Message) error {
+	p.mu.RLock()
+	handlers := p.handlers[message.EventType]
+	p.mu.RUnlock()
+
+	for _, handler := range handlers {
+		if err := handler(message); err != nil {
+			return fmt.Errorf("%w: handling %s message %s failed", err, message.EventType, message.ID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInProcessPublisher_Publish(t *testing.T) {
+	t.Run("Test dispatch by event type", func(t *testing.T) {
+		p := NewInProcessPublisher()
+
+		var created, deleted []string
+		p.Register("Created", func(message Message) error {
+			created = append(created, message.ID)
+			return nil
+		})
+		p.Register("Deleted", func(message Message) error {
+			deleted = append(deleted, message.ID)
+			return nil
+		})
+
+		assert.NoError(t, p.Publish("test", "test", Message{ID: "1", EventType: "Created"}))
+		assert.NoError(t, p.Publish("test", "test", Message{ID: "2", EventType: "Deleted"}))
+		assert.NoError(t, p.Publish("test", "test", Message{ID: "3", EventType: "Unknown"}))
+
+		assert.Equal(t, []string{"1"}, created)
+		assert.Equal(t, []string{"2"}, deleted)
+	})
+
+	t.Run("Test handler error is returned", func(t *testing.T) {
+		p := NewInProcessPublisher()
+		handlerErr := errors.New("handler failed")
+		p.Register("Created", func(message Message) error {
+			return handlerErr
+		})
+
+		err := p.Publish("test", "test", Message{ID: "1", EventType: "Created"})
+		assert.ErrorIs(t, err, handlerErr)
+	})
+
+	t.Run("Test failed messages are not consumed by relay", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.TODO(), time.Millisecond*500)
+		defer cancel()
+
+		p := NewInProcessPublisher()
+		p.Register("Test", func(message Message) error {
+			return errors.New("handler failed")
+		})
+
+		r := &RepositoryMock{Messages: GenerateMessages(10)}
+		relay := NewRelay(r, p, runtime.NumCPU(), time.Millisecond,
+			WithPublisherMiddlewares(RetryMiddleware(3, time.Millisecond)), WithLogger(NopLogger{}))
+
+		assert.NoError(t, relay.Run(ctx, BatchSize(10)))
+		assert.Empty(t, r.Consumed)
+	})
+}
@@ -0,0 +1,97 @@
+package outbox
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Field is a key-value pair attached to a log line
+type Field struct {
+	Key   string
+	Value any
+}
+
+func LogField(key string, value any) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Logger is a structured logger used by relay, repository and publishers
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, err error, fields ...Field)
+}
+
+// NopLogger discards all log lines
+type NopLogger struct{}
+
+func (NopLogger) Debug(string, ...Field)        {}
+func (NopLogger) Info(string, ...Field)         {}
+func (NopLogger) Warn(string, ...Field)         {}
+func (NopLogger) Error(string, error, ...Field) {}
+
+// ZerologLogger adapts zerolog.Logger to Logger
+type ZerologLogger struct {
+	logger *zerolog.Logger
+}
+
+func NewZerologLogger(logger *zerolog.Logger) *ZerologLogger {
+	return &ZerologLogger{logger: logger}
+}
+
+func (l *ZerologLogger) Debug(msg string, fields ...Field) {
+	withFields(l.logger.Debug(), fields).Msg(msg)
+}
+
+func (l *ZerologLogger) Info(msg string, fields ...Field) {
+	withFields(l.logger.Info(), fields).Msg(msg)
+}
+
+func (l *ZerologLogger) Warn(msg string, fields ...Field) {
+	withFields(l.logger.Warn(), fields).Msg(msg)
+}
+
+func (l *ZerologLogger) Error(msg string, err error, fields ...Field) {
+	withFields(l.logger.Error().Err(err), fields).Msg(msg)
+}
+
+func withFields(e *zerolog.Event, fields []Field) *zerolog.Event {
+	for _, f := range fields {
+		e = e.Interface(f.Key, f.Value)
+	}
+
+	return e
+}
+
+// defaultLogger logs with zerolog global logger
+func defaultLogger() Logger {
+	return NewZerologLogger(&log.Logger)
+}
+
+type batchIDKey struct{}
+
+func contextWithBatchID(ctx context.Context, id uint64) context.Context {
+	return context.WithValue(ctx, batchIDKey{}, id)
+}
+
+// batchFields returns identifier of relay batch ctx belongs to as log fields
+func batchFields(ctx context.Context) []Field {
+	id, ok := ctx.Value(batchIDKey{}).(uint64)
+	if !ok {
+		return nil
+	}
+
+	return []Field{LogField("batch_id", id)}
+}
+
+func messageFields(ctx context.Context, msg Message) []Field {
+	return append(batchFields(ctx),
+		LogField("message_id", msg.ID),
+		LogField("event_type", msg.EventType),
+		LogField("exchange", msg.Exchange),
+		LogField("partition_key", msg.PartitionKey.Int64),
+	)
+}
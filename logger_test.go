@@ -0,0 +1,80 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer is a buffer safe for concurrent writes
+type syncBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func TestZerologLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	zl := zerolog.New(buf)
+	l := NewZerologLogger(&zl)
+
+	l.Error("publish failed", errors.New("broker down"), LogField("message_id", "1"), LogField("partition", 2))
+
+	line := map[string]any{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "error", line["level"])
+	assert.Equal(t, "publish failed", line["message"])
+	assert.Equal(t, "broker down", line["error"])
+	assert.Equal(t, "1", line["message_id"])
+	assert.Equal(t, float64(2), line["partition"])
+}
+
+func TestRelay_Logger(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Millisecond*500)
+	defer cancel()
+
+	buf := &syncBuffer{}
+	zl := zerolog.New(buf)
+
+	p := NewInProcessPublisher()
+	p.Register("Test", func(message Message) error {
+		return errors.New("handler failed")
+	})
+
+	msg := GenerateMessages(1)[0]
+	r := &RepositoryMock{Messages: []Message{msg}}
+
+	relay := NewRelay(r, p, runtime.NumCPU(), time.Millisecond, WithLogger(NewZerologLogger(&zl)), WithPublisherMiddlewares())
+	assert.NoError(t, relay.Run(ctx, BatchSize(10)))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	line := map[string]any{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &line))
+	assert.Equal(t, "while publishing message", line["message"])
+	assert.Equal(t, msg.ID, line["message_id"])
+	assert.Equal(t, msg.Exchange, line["exchange"])
+	assert.Equal(t, float64(1), line["batch_id"])
+	assert.Contains(t, line, "partition")
+}
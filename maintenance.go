@@ -0,0 +1,83 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const purgeBatchSize = 1000
+
+// MessageStats are counts of stored messages of an exchange and event type
+type MessageStats struct {
+	Exchange  string
+	EventType string
+	Pending   int64
+	Scheduled int64
+	Failed    int64
+	Consumed  int64
+	Expired   int64
+	// OldestPendingAt is the earliest time a pending message became due, scheduled messages are excluded
+	OldestPendingAt time.Time
+}
+
+// Stats returns counts of stored messages grouped by exchange and event type
+func (r *Repository) Stats(ctx context.Context) ([]MessageStats, error) {
+	query := fmt.Sprintf(`
+SELECT exchange, event_type,
+       count(*) FILTER (WHERE consumed = $1 AND failures = 0 AND (publish_at IS NULL OR publish_at <= now())),
+       count(*) FILTER (WHERE consumed = $1 AND publish_at > now()),
+       count(*) FILTER (WHERE consumed = $1 AND failures > 0),
+       count(*) FILTER (WHERE consumed = $2 AND expired_at IS NULL),
+       count(*) FILTER (WHERE expired_at IS NOT NULL),
+       min(COALESCE(publish_at, created_at::timestamptz)) FILTER (WHERE consumed = $1 AND (publish_at IS NULL OR publish_at <= now()))
+FROM %s
+GROUP BY exchange, event_type
+ORDER BY exchange, event_type
+`, TableName)
+
+	rows, err := r.db.Query(ctx, query, statusNotConsumed, statusConsumed)
+	if err != nil {
+		return nil, fmt.Errorf("while querying stats: %w", err)
+	}
+	defer rows.Close() //nolint
+
+	stats := make([]MessageStats, 0)
+	for rows.Next() {
+		var (
+			s      MessageStats
+			oldest sql.NullTime
+		)
+		if err = rows.Scan(&s.Exchange, &s.EventType, &s.Pending, &s.Scheduled, &s.Failed, &s.Consumed, &s.Expired, &oldest); err != nil {
+			return nil, fmt.Errorf("while scan stats: %w", err)
+		}
+		s.OldestPendingAt = oldest.Time
+
+		stats = append(stats, s)
+	}
+
+	return stats, nil
+}
+
+// PurgeConsumed deletes consumed messages created before olderThan in batches
+// and returns number of deleted messages
+func (r *Repository) PurgeConsumed(ctx context.Context, olderThan time.Time) (int64, error) {
+	query := fmt.Sprintf(`
+DELETE FROM %s WHERE event_id IN (
+    SELECT event_id FROM %s WHERE consumed = $1 AND created_at < $2 LIMIT $3
+) RETURNING event_id`, TableName, TableName)
+
+	var total int64
+	for {
+		n, err := r.count(ctx, query, statusConsumed, olderThan, purgeBatchSize)
+		if err != nil {
+			return total, fmt.Errorf("while purging consumed messages: %w", err)
+		}
+
+		total += n
+		if n < purgeBatchSize {
+			return total, nil
+		}
+	}
+}
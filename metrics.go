@@ -0,0 +1,57 @@
+package outbox
+
+import (
+	"context"
+	"time"
+)
+
+// Metrics receives measurements of the relay pipeline
+type Metrics interface {
+	MessagesFetched(n int)
+	MessagePublished(exchange string, latency time.Duration)
+	MessagePublishFailed(exchange string, latency time.Duration)
+	MessageExpired(exchange string)
+	MessagesMarkedConsumed(n int)
+	MarkConsumedFailed(n int)
+	MarkExpiredFailed(n int)
+	BatchProcessed(duration time.Duration)
+	Backlog(stats BacklogStats)
+	CircuitStateChanged(state CircuitState)
+}
+
+// BacklogStats describes messages waiting to be published
+type BacklogStats struct {
+	Size int64
+	// OldestDueAt is the earliest time a message of backlog became due,
+	// publish time of scheduled messages and creation time of others
+	OldestDueAt time.Time
+}
+
+// OldestAge returns how long the oldest due message is waiting, zero when backlog is empty
+func (s BacklogStats) OldestAge(now time.Time) time.Duration {
+	if s.Size == 0 || s.OldestDueAt.IsZero() {
+		return 0
+	}
+
+	return now.Sub(s.OldestDueAt)
+}
+
+// BacklogReporter is implemented by repositories able to report backlog stats,
+// relay reports them to Metrics after every batch
+type BacklogReporter interface {
+	Backlog(ctx context.Context) (BacklogStats, error)
+}
+
+// NopMetrics discards all measurements
+type NopMetrics struct{}
+
+func (NopMetrics) MessagesFetched(int)                        {}
+func (NopMetrics) MessagePublished(string, time.Duration)     {}
+func (NopMetrics) MessagePublishFailed(string, time.Duration) {}
+func (NopMetrics) MessageExpired(string)                      {}
+func (NopMetrics) MessagesMarkedConsumed(int)                 {}
+func (NopMetrics) MarkConsumedFailed(int)                     {}
+func (NopMetrics) MarkExpiredFailed(int)                      {}
+func (NopMetrics) BatchProcessed(time.Duration)               {}
+func (NopMetrics) Backlog(BacklogStats)                       {}
+func (NopMetrics) CircuitStateChanged(CircuitState)           {}
@@ -0,0 +1,108 @@
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/avast/retry-go"
+)
+
+// PublisherFunc adapts a function to Publisher
+type PublisherFunc func(exchange, topic string, message Message) error
+
+func (f PublisherFunc) Publish(exchange, topic string, message Message) error {
+	return f(exchange, topic, message)
+}
+
+// PublisherMiddleware wraps Publisher with cross-cutting behaviour
+type PublisherMiddleware func(next Publisher) Publisher
+
+// ChainPublisher wraps publisher with middlewares, the first middleware is the outermost
+func ChainPublisher(publisher Publisher, middlewares ...PublisherMiddleware) Publisher {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		publisher = middlewares[i](publisher)
+	}
+
+	return publisher
+}
+
+// RetryMiddleware retries failed publishing with exponential backoff starting from delay,
+// retrying stops when context of message is done or circuit breaker is open, the last error is returned
+func RetryMiddleware(attempts uint, delay time.Duration) PublisherMiddleware {
+	return func(next Publisher) Publisher {
+		return PublisherFunc(func(exchange, topic string, message Message) error {
+			return retryPublish(next, exchange, topic, message, attempts, delay)
+		})
+	}
+}
+
+// defaultRetryMiddleware retries publishing with PublishRetryAttempts and PublishRetryDelay read on every publish
+func defaultRetryMiddleware() PublisherMiddleware {
+	return func(next Publisher) Publisher {
+		return PublisherFunc(func(exchange, topic string, message Message) error {
+			return retryPublish(next, exchange, topic, message, PublishRetryAttempts, PublishRetryDelay)
+		})
+	}
+}
+
+func retryPublish(next Publisher, exchange, topic string, message Message, attempts uint, delay time.Duration) error {
+	return retry.Do(
+		func() error {
+			return next.Publish(exchange, topic, message)
+		},
+		retry.Attempts(attempts),
+		retry.Delay(delay),
+		retry.Context(message.Context()),
+		retry.RetryIf(func(err error) bool {
+			return !errors.Is(err, ErrCircuitOpen)
+		}),
+		retry.LastErrorOnly(true),
+	)
+}
+
+// RecoverMiddleware converts publisher panics to errors
+func RecoverMiddleware() PublisherMiddleware {
+	return func(next Publisher) Publisher {
+		return PublisherFunc(func(exchange, topic string, message Message) (err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					err = fmt.Errorf("publisher panic: %v", p)
+				}
+			}()
+
+			return next.Publish(exchange, topic, message)
+		})
+	}
+}
+
+// FilterMiddleware publishes only messages matched by match, others are acknowledged without publishing
+func FilterMiddleware(match RouteMatcher) PublisherMiddleware {
+	return func(next Publisher) Publisher {
+		return PublisherFunc(func(exchange, topic string, message Message) error {
+			if !match(message) {
+				return nil
+			}
+
+			return next.Publish(exchange, topic, message)
+		})
+	}
+}
+
+// HeadersMiddleware adds static headers to published messages, existing headers are kept
+func HeadersMiddleware(headers Headers) PublisherMiddleware {
+	return func(next Publisher) Publisher {
+		return PublisherFunc(func(exchange, topic string, message Message) error {
+			merged := make(Headers, len(headers)+len(message.Headers))
+			for k, v := range headers {
+				merged[k] = v
+			}
+			for k, v := range message.Headers {
+				merged[k] = v
+			}
+			message.Headers = merged
+
+			return next.Publish(exchange, topic, message)
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainPublisher(t *testing.T) {
+	t.Run("Test the first middleware is the outermost", func(t *testing.T) {
+		var calls []string
+		mw := func(name string) PublisherMiddleware {
+			return func(next Publisher) Publisher {
+				return PublisherFunc(func(exchange, topic string, message Message) error {
+					calls = append(calls, name)
+					return next.Publish(exchange, topic, message)
+				})
+			}
+		}
+
+		p := &PublisherMock{}
+		assert.NoError(t, ChainPublisher(p, mw("first"), mw("second")).Publish("test", "test", Message{ID: "1"}))
+
+		assert.Equal(t, []string{"first", "second"}, calls)
+		assert.Len(t, p.Published, 1)
+	})
+}
+
+func TestRetryMiddleware(t *testing.T) {
+	t.Run("Test publishing is retried", func(t *testing.T) {
+		p := &failingPublisherMock{failures: 2}
+		assert.NoError(t, RetryMiddleware(3, time.Millisecond)(p).Publish("test", "test", Message{ID: "1"}))
+		assert.Len(t, p.Published, 1)
+
+		p = &failingPublisherMock{failures: 3}
+		assert.Error(t, RetryMiddleware(3, time.Millisecond)(p).Publish("test", "test", Message{ID: "1"}))
+		assert.Empty(t, p.Published)
+	})
+
+	t.Run("Test retrying stops when context of message is done", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		p := PublisherFunc(func(exchange, topic string, message Message) error {
+			return errors.New("broker down")
+		})
+
+		start := time.Now()
+		err := RetryMiddleware(10, 50*time.Millisecond)(p).Publish("test", "test", Message{}.WithContext(ctx))
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), 200*time.Millisecond)
+	})
+
+	t.Run("Test default retry reads settings on publish", func(t *testing.T) {
+		attempts, delay := PublishRetryAttempts, PublishRetryDelay
+		defer func() {
+			PublishRetryAttempts, PublishRetryDelay = attempts, delay
+		}()
+
+		publisher := defaultRetryMiddleware()(&failingPublisherMock{failures: 1000})
+		PublishRetryAttempts, PublishRetryDelay = 1, time.Millisecond
+
+		start := time.Now()
+		assert.Error(t, publisher.Publish("test", "test", Message{}))
+		assert.Less(t, time.Since(start), 100*time.Millisecond)
+	})
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	t.Run("Test panic is returned as error", func(t *testing.T) {
+		p := PublisherFunc(func(exchange, topic string, message Message) error {
+			panic("broken")
+		})
+
+		assert.EqualError(t, RecoverMiddleware()(p).Publish("test", "test", Message{}), "publisher panic: broken")
+	})
+}
+
+func TestFilterMiddleware(t *testing.T) {
+	t.Run("Test only matched messages are published", func(t *testing.T) {
+		p := &PublisherMock{}
+		f := FilterMiddleware(MatchExchanges("orders"))(p)
+
+		assert.NoError(t, f.Publish("orders", "test", Message{Exchange: "orders"}))
+		assert.NoError(t, f.Publish("users", "test", Message{Exchange: "users"}))
+		assert.Len(t, p.Published, 1)
+	})
+}
+
+func TestHeadersMiddleware(t *testing.T) {
+	t.Run("Test static headers do not override message headers", func(t *testing.T) {
+		p := &PublisherMock{}
+		h := HeadersMiddleware(Headers{"source": "outbox", "version": "1"})(p)
+
+		assert.NoError(t, h.Publish("test", "test", Message{Headers: Headers{"version": "2"}}))
+		assert.Equal(t, Headers{"source": "outbox", "version": "2"}, p.Published[0].Headers)
+	})
+}
@@ -20,11 +20,18 @@ func (m *RepositoryMock) Fetch(ctx context.Context, batchSize BatchSize) <-chan
 	go func() {
 		defer close(ch)
 
-		for _, m := range m.Messages {
-			ch <- m
-		}
-
+		m.mu.Lock()
+		messages := append([]Message(nil), m.Messages...)
 		m.Messages = m.Messages[:0]
+		m.mu.Unlock()
+
+		for _, msg := range messages {
+			select {
+			case ch <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
 	}()
 
 	return ch
@@ -3,7 +3,10 @@ package outbox
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"hash/fnv"
 	"time"
 )
@@ -17,6 +20,7 @@ const (
 
 var (
 	ErrBatchSizeOutOfRange = errors.New("invalid batch size")
+	ErrPriorityOutOfRange  = errors.New("priority out of range")
 
 	TableName                  = "outbox_messages"
 	PublishRetryDelay          = time.Second
@@ -63,17 +67,153 @@ type Message struct {
 	RoutingKey   string
 	Consumed     bool
 	CreatedAt    time.Time
+	Headers      Headers
+	// Codec is name of codec payload is encoded with, persister codec is used when empty
+	Codec string
+	// Compression is algorithm payload is compressed with, payload is not compressed when empty
+	Compression string
+	// IdempotencyKey identifies logical event, messages with already stored key are not persisted again
+	IdempotencyKey string
+	// PublishAt delays publishing of message until the time
+	PublishAt sql.NullTime
+	// ExpiresAt is time after which message is not published
+	ExpiresAt sql.NullTime
+	// Priority of message, higher priorities are served first when repository fetches by priority.
+	// It is stored as smallint, persisters reject priorities out of int16 range.
+	Priority int
+
+	// keyID and dataKey are set to id of key and data key wrapped with it while payload is encrypted
+	keyID   string
+	dataKey []byte
+	// claimCheck is key of blob payload is stored in
+	claimCheck string
+	// ctx is context message is published with
+	ctx context.Context
+}
+
+// Context returns context message is published with, relay cancels it when batch is cancelled.
+// Background context is returned for messages not being published.
+func (m Message) Context() context.Context {
+	if m.ctx != nil {
+		return m.ctx
+	}
+
+	return context.Background()
+}
+
+// WithContext returns copy of message published with ctx
+func (m Message) WithContext(ctx context.Context) Message {
+	m.ctx = ctx
+
+	return m
 }
 
+// BytePayload returns encoded payload, string and []byte payloads are returned as is.
+// Payload of message with Compression is returned compressed.
 func (m *Message) BytePayload() ([]byte, error) {
-	switch p := m.Payload.(type) {
+	c, err := CodecByName(m.Codec)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := encodePayload(c, m.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("while encoding payload with %s codec: %w", c.Name(), err)
+	}
+
+	return b, nil
+}
+
+// ContentType returns content type of encoded payload
+func (m *Message) ContentType() string {
+	c, err := CodecByName(m.Codec)
+	if err != nil {
+		return "application/octet-stream"
+	}
+
+	return c.ContentType()
+}
+
+// Expired reports whether message expired at now
+func (m *Message) Expired(now time.Time) bool {
+	return m.ExpiresAt.Valid && !now.Before(m.ExpiresAt.Time)
+}
+
+// plainPayload returns encoded payload decompressed
+func (m *Message) plainPayload() ([]byte, error) {
+	b, err := m.BytePayload()
+	if err != nil {
+		return nil, err
+	}
+
+	if b, err = decompress(m.Compression, b); err != nil {
+		return nil, fmt.Errorf("while decompressing payload: %w", err)
+	}
+
+	return b, nil
+}
+
+// Decode decodes payload into v with codec message is encoded with
+func (m *Message) Decode(v any) error {
+	c, err := CodecByName(m.Codec)
+	if err != nil {
+		return err
+	}
+
+	b, err := m.plainPayload()
+	if err != nil {
+		return err
+	}
+
+	if err = c.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("while decoding payload with %s codec: %w", c.Name(), err)
+	}
+
+	return nil
+}
+
+// Headers are message metadata such as trace context, stored as jsonb
+type Headers map[string]string
+
+func (h *Headers) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*h = nil
+		return nil
 	case string:
-		return []byte(p), nil
-	case []uint8:
-		return p, nil
+		data = []byte(v)
+	case []byte:
+		data = v
 	default:
-		return nil, errors.New("unsupported payload type")
+		return fmt.Errorf("unsupported headers type %T", src)
 	}
+
+	return json.Unmarshal(data, h)
+}
+
+func (h Headers) Value() (driver.Value, error) {
+	if len(h) == 0 {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+// withHeader returns copy of h with header k set to v, so headers of caller message are not modified
+func (h Headers) withHeader(k, v string) Headers {
+	headers := make(Headers, len(h)+1)
+	for key, value := range h {
+		headers[key] = value
+	}
+	headers[k] = v
+
+	return headers
 }
 
 type EventRepository interface {
@@ -81,6 +221,12 @@ type EventRepository interface {
 	MarkConsumed(ctx context.Context, msgs []Message) error
 }
 
+// ExpirationRecorder is implemented by repositories recording expired messages apart from published ones,
+// relay marks expired messages consumed when repository does not implement it
+type ExpirationRecorder interface {
+	MarkExpired(ctx context.Context, msgs []Message) error
+}
+
 func partitionKey(s string) int {
 	// Create an FNV-1a hash of the input string
 	h := fnv.New32a()
@@ -89,3 +235,17 @@ func partitionKey(s string) int {
 	// Map the hash value to a partition within the specified range
 	return int(h.Sum32())
 }
+
+// detachedContext keeps values of parent context but is never cancelled
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+func (c detachedContext) Value(key any) any         { return c.parent.Value(key) }
+
+func withoutCancel(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
@@ -2,105 +2,368 @@ package outbox
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"gorm.io/gorm"
 )
 
-func NewPgxPersister(db *pgxpool.Pool) *PgxPersister {
-	return &PgxPersister{db: db}
+const persistColumns = `event_id, event_type, payload, exchange, routing_key, partition_key, headers, codec, compression,
+key_id, data_key, claim_check, idempotency_key, publish_at, expires_at, priority`
+
+type PersisterOption func(c *persisterConfig)
+
+// WithCodec sets codec encoding payloads of messages without codec, JSON is used by default.
+// Codecs other than built-in JSON, Protobuf and MessagePack must be registered with RegisterCodec
+// to decode fetched messages.
+func WithCodec(c Codec) PersisterOption {
+	return func(cfg *persisterConfig) {
+		cfg.codec = c
+	}
+}
+
+// WithEventRegistry makes persister reject messages of event types not registered in r
+// or with payloads of other types
+func WithEventRegistry(r *EventRegistry) PersisterOption {
+	return func(cfg *persisterConfig) {
+		cfg.events = r
+	}
+}
+
+// WithSchemaValidator makes persister reject messages with payloads not matching their schema,
+// id of matched schema is stored in HeaderSchemaID header
+func WithSchemaValidator(v *SchemaValidator) PersisterOption {
+	return func(cfg *persisterConfig) {
+		cfg.schemas = v
+	}
+}
+
+// WithCompression makes persister compress payloads of at least threshold bytes with algorithm:
+// CompressionGzip, CompressionZstd or CompressionSnappy
+func WithCompression(algorithm string, threshold int) PersisterOption {
+	return func(cfg *persisterConfig) {
+		cfg.compression = algorithm
+		cfg.compressionThreshold = threshold
+	}
+}
+
+// WithEncryption makes persister encrypt payloads with data keys wrapped by provider
+func WithEncryption(provider KeyProvider) PersisterOption {
+	return func(cfg *persisterConfig) {
+		cfg.keys = provider
+	}
+}
+
+// WithClaimCheck makes persister store payloads of at least threshold bytes in store
+// and keep only reference to them in outbox table
+func WithClaimCheck(store BlobStore, threshold int) PersisterOption {
+	return func(cfg *persisterConfig) {
+		cfg.blobs = store
+		cfg.claimCheckThreshold = threshold
+	}
+}
+
+type persisterConfig struct {
+	blobs                BlobStore
+	claimCheckThreshold  int
+	keys                 KeyProvider
+	codec                Codec
+	events               *EventRegistry
+	schemas              *SchemaValidator
+	compression          string
+	compressionThreshold int
+}
+
+func newPersisterConfig(opts []PersisterOption) persisterConfig {
+	cfg := persisterConfig{codec: JSONCodec{}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cfg
+}
+
+// prepare stores trace context of ctx with message and encodes its payload
+func (c persisterConfig) prepare(ctx context.Context, msg *Message) error {
+	if msg.Priority < math.MinInt16 || msg.Priority > math.MaxInt16 {
+		return fmt.Errorf("%w: priority %d of message %s", ErrPriorityOutOfRange, msg.Priority, msg.ID)
+	}
+
+	codec := c.codec
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+	if msg.Codec != "" {
+		var err error
+		if codec, err = CodecByName(msg.Codec); err != nil {
+			return err
+		}
+	}
+
+	if c.events != nil {
+		if err := c.events.Validate(*msg, codec); err != nil {
+			return fmt.Errorf("%w: validating message %s failed", err, msg.ID)
+		}
+	}
+
+	injectTraceContext(ctx, msg)
+
+	payload, err := encodePayload(codec, msg.Payload)
+	if err != nil {
+		return fmt.Errorf("%w: encoding payload of message %s failed", err, msg.ID)
+	}
+
+	msg.Payload = payload
+	msg.Codec = codec.Name()
+
+	if c.schemas != nil {
+		schema, err := c.schemas.Validate(ctx, *msg)
+		if err != nil {
+			return fmt.Errorf("%w: validating payload of message %s failed", err, msg.ID)
+		}
+		msg.Headers = msg.Headers.withHeader(HeaderSchemaID, strconv.Itoa(schema.ID))
+	}
+
+	if c.compression != "" && len(payload) >= c.compressionThreshold {
+		if msg.Payload, err = compress(c.compression, payload); err != nil {
+			return fmt.Errorf("%w: compressing payload of message %s failed", err, msg.ID)
+		}
+		msg.Compression = c.compression
+	}
+
+	if c.keys != nil {
+		if err = encryptPayload(ctx, c.keys, msg, msg.Payload.([]byte)); err != nil {
+			return fmt.Errorf("%w: encrypting payload of message %s failed", err, msg.ID)
+		}
+	}
+
+	if stored := msg.Payload.([]byte); c.blobs != nil && len(stored) >= c.claimCheckThreshold {
+		if err = checkPayload(ctx, c.blobs, msg, stored); err != nil {
+			return fmt.Errorf("%w: storing payload of message %s failed", err, msg.ID)
+		}
+	}
+
+	return nil
+}
+
+// PersistResult reports persisted messages and messages skipped as duplicates by idempotency key
+type PersistResult struct {
+	Persisted    []Message
+	Deduplicated []Message
+}
+
+func (r *PersistResult) add(msg Message, inserted int64) {
+	if inserted == 0 {
+		r.Deduplicated = append(r.Deduplicated, msg)
+		return
+	}
+
+	r.Persisted = append(r.Persisted, msg)
+}
+
+// idempotencyKeyQuery checks if message with idempotency key of placeholder is already stored
+func idempotencyKeyQuery(placeholder string) string {
+	return fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE idempotency_key = %s)", TableName, placeholder)
+}
+
+// insertQuery builds insert of persistColumns with placeholder of n-th value,
+// messages with already stored idempotency key are skipped
+func insertQuery(placeholder func(n int) string) string {
+	n := len(strings.Split(persistColumns, ","))
+	values := make([]string, 0, n)
+	for i := 1; i <= n; i++ {
+		values = append(values, placeholder(i))
+	}
+
+	return fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES(%s) ON CONFLICT (idempotency_key) WHERE idempotency_key IS NOT NULL DO NOTHING",
+		TableName, persistColumns, strings.Join(values, ", "),
+	)
+}
+
+func persistValues(msg Message) []any {
+	return []any{
+		msg.ID,
+		msg.EventType,
+		msg.Payload,
+		msg.Exchange,
+		msg.RoutingKey,
+		msg.PartitionKey,
+		msg.Headers,
+		msg.Codec,
+		msg.Compression,
+		msg.keyID,
+		msg.dataKey,
+		msg.claimCheck,
+		sql.NullString{String: msg.IdempotencyKey, Valid: msg.IdempotencyKey != ""},
+		msg.PublishAt,
+		msg.ExpiresAt,
+		msg.Priority,
+	}
+}
+
+func NewPgxPersister(db *pgxpool.Pool, opts ...PersisterOption) *PgxPersister {
+	return &PgxPersister{db: db, cfg: newPersisterConfig(opts)}
 }
 
 type PgxPersister struct {
-	db *pgxpool.Pool
+	db  *pgxpool.Pool
+	cfg persisterConfig
 }
 
 func (r *PgxPersister) PersistInTx(ctx context.Context, fn func(tx pgx.Tx) ([]Message, error)) error {
+	_, err := r.PersistInTxWithResult(ctx, fn)
+
+	return err
+}
+
+// PersistInTxWithResult persists messages and reports messages skipped
+// because a message with the same idempotency key is already stored
+func (r *PgxPersister) PersistInTxWithResult(ctx context.Context, fn func(tx pgx.Tx) ([]Message, error)) (result PersistResult, err error) {
+	ctx, span := startPersistSpan(ctx)
+	defer func() { endSpan(span, err) }()
+
 	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
-		return fmt.Errorf("%w: transaction begin failed", err)
+		return result, fmt.Errorf("%w: transaction begin failed", err)
 	}
 
 	messages, err := fn(tx)
 	if err != nil {
 		if rollbackErr := tx.Rollback(ctx); rollbackErr != nil {
-			return fmt.Errorf("%w: transaction rollback while fn exec", rollbackErr)
+			return result, fmt.Errorf("%w: transaction rollback while fn exec", rollbackErr)
 		}
 
-		return err
+		return result, err
 	}
 
-	query := fmt.Sprintf(`
-INSERT INTO %s (event_id, event_type, payload, exchange, routing_key, partition_key)
-VALUES($1, $2, $3, $4, $5, $6)
-`, TableName)
-
-	for _, event := range messages {
-		_, err = tx.Exec(
-			ctx,
-			query,
-			event.ID,
-			event.EventType,
-			event.Payload,
-			event.Exchange,
-			event.RoutingKey,
-			event.PartitionKey,
-		)
+	query := insertQuery(func(n int) string { return fmt.Sprintf("$%d", n) })
 
+	for _, message := range messages {
+		inserted, err := r.persist(ctx, tx, query, message)
 		if err != nil {
 			if rollbackErr := tx.Rollback(ctx); rollbackErr != nil {
-				return fmt.Errorf("%w: transaction rollback failed while of query exec", rollbackErr)
+				return PersistResult{}, fmt.Errorf("%w: transaction rollback failed while of query exec", rollbackErr)
 			}
 
-			return fmt.Errorf("%w: messages persist failed", err)
+			return PersistResult{}, fmt.Errorf("%w: messages persist failed", err)
 		}
+
+		result.add(message, inserted)
 	}
 
 	if err = tx.Commit(ctx); err != nil {
-		return fmt.Errorf("%w: transaction commit failed", err)
+		return PersistResult{}, fmt.Errorf("%w: transaction commit failed", err)
 	}
 
-	return nil
+	return result, nil
 }
 
-func NewGormPersister(db *gorm.DB) *GormPersister {
-	return &GormPersister{db: db}
+// persist inserts message unless its idempotency key is already stored,
+// duplicates are skipped before payload is encrypted or moved to blob store
+func (r *PgxPersister) persist(ctx context.Context, tx pgx.Tx, query string, message Message) (int64, error) {
+	if message.IdempotencyKey != "" {
+		var exists bool
+		if err := tx.QueryRow(ctx, idempotencyKeyQuery("$1"), message.IdempotencyKey).Scan(&exists); err != nil {
+			return 0, fmt.Errorf("while checking idempotency key: %w", err)
+		}
+		if exists {
+			return 0, nil
+		}
+	}
+
+	if err := r.cfg.prepare(ctx, &message); err != nil {
+		return 0, err
+	}
+
+	tag, err := tx.Exec(ctx, query, persistValues(message)...)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+func NewGormPersister(db *gorm.DB, opts ...PersisterOption) *GormPersister {
+	return &GormPersister{db: db, cfg: newPersisterConfig(opts)}
 }
 
 type GormPersister struct {
-	db *gorm.DB
+	db  *gorm.DB
+	cfg persisterConfig
 }
 
+// WithContext returns persister running transactions with ctx
+func (r *GormPersister) WithContext(ctx context.Context) *GormPersister {
+	return &GormPersister{db: r.db.WithContext(ctx), cfg: r.cfg}
+}
+
+// PersistInTx stores trace context of persister context with messages,
+// use WithContext to propagate it
 func (r *GormPersister) PersistInTx(fn func(tx *gorm.DB) ([]Message, error)) error {
-	return r.db.Transaction(func(tx *gorm.DB) error {
+	_, err := r.PersistInTxWithResult(fn)
+
+	return err
+}
+
+// PersistInTxWithResult persists messages and reports messages skipped
+// because a message with the same idempotency key is already stored
+func (r *GormPersister) PersistInTxWithResult(fn func(tx *gorm.DB) ([]Message, error)) (result PersistResult, err error) {
+	ctx, span := startPersistSpan(r.db.Statement.Context)
+	defer func() { endSpan(span, err) }()
+
+	err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		messages, err := fn(tx)
 		if err != nil {
 			return err
 		}
 
-		query := fmt.Sprintf(`
-INSERT INTO %s (event_id, event_type, payload, exchange, routing_key, partition_key)
-VALUES(?, ?, ?, ?, ?, ?)
-`, TableName)
-
-		for _, event := range messages {
-			err := tx.Exec(
-				query,
-				event.ID,
-				event.EventType,
-				event.Payload,
-				event.Exchange,
-				event.RoutingKey,
-				event.PartitionKey,
-			).Error
+		query := insertQuery(func(int) string { return "?" })
 
+		for _, message := range messages {
+			inserted, err := r.persist(ctx, tx, query, message)
 			if err != nil {
 				return fmt.Errorf("%w: messages persist failed", err)
 			}
+
+			result.add(message, inserted)
 		}
 
 		return nil
 	})
+	if err != nil {
+		return PersistResult{}, err
+	}
+
+	return result, nil
+}
+
+// persist inserts message unless its idempotency key is already stored,
+// duplicates are skipped before payload is encrypted or moved to blob store
+func (r *GormPersister) persist(ctx context.Context, tx *gorm.DB, query string, message Message) (int64, error) {
+	if message.IdempotencyKey != "" {
+		var exists bool
+		if err := tx.Raw(idempotencyKeyQuery("?"), message.IdempotencyKey).Row().Scan(&exists); err != nil {
+			return 0, fmt.Errorf("while checking idempotency key: %w", err)
+		}
+		if exists {
+			return 0, nil
+		}
+	}
+
+	if err := r.cfg.prepare(ctx, &message); err != nil {
+		return 0, err
+	}
+
+	res := tx.Exec(query, persistValues(message)...)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+
+	return res.RowsAffected, nil
 }
@@ -3,6 +3,8 @@ package outbox
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"os"
 	"testing"
 
 	"github.com/jackc/pgx/v5"
@@ -20,7 +22,7 @@ type PgxPersisterTestSuite struct {
 func (suite *PgxPersisterTestSuite) SetupTest() {
 	suite.TestSuite.SetupTest()
 	suite.p = &PgxPersister{db: suite.pgxDB}
-	suite.r = &Repository{db: NewPGXAdapter(suite.pgxDB)}
+	suite.r = NewRepository(NewPGXAdapter(suite.pgxDB))
 }
 
 func (suite *PgxPersisterTestSuite) TestPersistInTx() {
@@ -53,6 +55,85 @@ func (suite *PgxPersisterTestSuite) TestPersistInTx() {
 	suite.Equal(4, len(c))
 }
 
+func (suite *PgxPersisterTestSuite) TestPersistWithCodec() {
+	defer suite.cleanDB()
+
+	p := NewPgxPersister(suite.pgxDB, WithCodec(MessagePackCodec{}))
+	err := p.PersistInTx(context.Background(), func(tx pgx.Tx) ([]Message, error) {
+		return []Message{
+			{ID: "f53ec986-345f-48a4-b248-430a7d7f342f", Payload: map[string]string{"a": "b"}},
+			{ID: "f53ec986-345f-48a4-b248-430a7d7f342e", Payload: map[string]string{"c": "d"}, Codec: CodecJSON},
+		}, nil
+	})
+	suite.Require().NoError(err)
+
+	for m := range suite.r.Fetch(context.TODO(), 100) {
+		var payload map[string]string
+		suite.NoError(m.Decode(&payload))
+
+		switch m.ID {
+		case "f53ec986-345f-48a4-b248-430a7d7f342f":
+			suite.Equal(CodecMessagePack, m.Codec)
+			suite.Equal(map[string]string{"a": "b"}, payload)
+		case "f53ec986-345f-48a4-b248-430a7d7f342e":
+			suite.Equal(CodecJSON, m.Codec)
+			suite.Equal("application/json", m.ContentType())
+			suite.Equal(map[string]string{"c": "d"}, payload)
+		}
+	}
+}
+
+func (suite *PgxPersisterTestSuite) TestPersistIdempotent() {
+	defer suite.cleanDB()
+
+	persist := func(id, otherID string) PersistResult {
+		result, err := suite.p.PersistInTxWithResult(context.Background(), func(tx pgx.Tx) ([]Message, error) {
+			return []Message{
+				{ID: id, Payload: `{}`, IdempotencyKey: "order-1-created"},
+				{ID: otherID, Payload: `{}`},
+			}, nil
+		})
+		suite.Require().NoError(err)
+
+		return result
+	}
+
+	result := persist("f53ec986-345f-48a4-b248-430a7d7f342f", "f53ec986-345f-48a4-b248-430a7d7f3421")
+	suite.Len(result.Persisted, 2)
+	suite.Empty(result.Deduplicated)
+
+	result = persist("f53ec986-345f-48a4-b248-430a7d7f342e", "f53ec986-345f-48a4-b248-430a7d7f3422")
+	suite.Len(result.Persisted, 1)
+	suite.Require().Len(result.Deduplicated, 1)
+	suite.Equal("f53ec986-345f-48a4-b248-430a7d7f342e", result.Deduplicated[0].ID)
+
+	keys := map[string]int{}
+	for m := range suite.r.Fetch(context.TODO(), 100) {
+		keys[m.IdempotencyKey]++
+	}
+	suite.Equal(map[string]int{"order-1-created": 1, "": 2}, keys)
+}
+
+func (suite *PgxPersisterTestSuite) TestPersistDuplicateSkipsBlobStore() {
+	defer suite.cleanDB()
+
+	dir := suite.T().TempDir()
+	store, err := NewFileBlobStore(dir)
+	suite.Require().NoError(err)
+
+	p := NewPgxPersister(suite.pgxDB, WithClaimCheck(store, 0))
+	for _, id := range []string{"f53ec986-345f-48a4-b248-430a7d7f342f", "f53ec986-345f-48a4-b248-430a7d7f342e"} {
+		err = p.PersistInTx(context.Background(), func(tx pgx.Tx) ([]Message, error) {
+			return []Message{{ID: id, Payload: `{}`, IdempotencyKey: "order-1-created"}}, nil
+		})
+		suite.Require().NoError(err)
+	}
+
+	blobs, err := os.ReadDir(dir)
+	suite.Require().NoError(err)
+	suite.Len(blobs, 1)
+}
+
 func TestPgxPersister(t *testing.T) {
 	suite.Run(t, new(PgxPersisterTestSuite))
 }
@@ -67,7 +148,7 @@ type GormPersisterTestSuite struct {
 func (suite *GormPersisterTestSuite) SetupTest() {
 	suite.TestSuite.SetupTest()
 	suite.p = &GormPersister{db: suite.gormDB}
-	suite.r = &Repository{db: NewGORMAdapter(suite.gormDB)}
+	suite.r = NewRepository(NewGORMAdapter(suite.gormDB))
 }
 
 func (suite *GormPersisterTestSuite) TestPersistInTx() {
@@ -94,6 +175,40 @@ func (suite *GormPersisterTestSuite) TestPersistInTx() {
 	suite.Equal(4, len(c))
 }
 
+func (suite *GormPersisterTestSuite) TestPersistWithCompression() {
+	defer suite.cleanDB()
+
+	p := NewGormPersister(suite.gormDB, WithCompression(CompressionZstd, 0))
+	err := p.PersistInTx(func(tx *gorm.DB) ([]Message, error) {
+		return []Message{
+			{ID: "f53ec986-345f-48a4-b248-430a7d7f342f", Payload: map[string]string{"a": "b"}},
+		}, nil
+	})
+	suite.Require().NoError(err)
+
+	for m := range suite.r.Fetch(context.TODO(), 100) {
+		suite.Empty(m.Compression)
+		suite.Equal(`{"a":"b"}`, string(m.Payload.(json.RawMessage)))
+	}
+}
+
+func (suite *GormPersisterTestSuite) TestPersistIdempotent() {
+	defer suite.cleanDB()
+
+	for _, id := range []string{"f53ec986-345f-48a4-b248-430a7d7f342f", "f53ec986-345f-48a4-b248-430a7d7f342e"} {
+		result, err := suite.p.PersistInTxWithResult(func(tx *gorm.DB) ([]Message, error) {
+			return []Message{{ID: id, Payload: `{}`, IdempotencyKey: "order-1-created"}}, nil
+		})
+		suite.Require().NoError(err)
+
+		if id == "f53ec986-345f-48a4-b248-430a7d7f342f" {
+			suite.Len(result.Persisted, 1)
+		} else {
+			suite.Len(result.Deduplicated, 1)
+		}
+	}
+}
+
 func TestGormPersister(t *testing.T) {
 	suite.Run(t, new(GormPersisterTestSuite))
 }
@@ -0,0 +1,69 @@
+package outbox
+
+import (
+	"fmt"
+	"sort"
+)
+
+// PriorityWeights maps message priority to its weight in a batch
+type PriorityWeights map[int]int
+
+// WithPriorityFetching makes Fetch share batches between priorities with weighted fair share:
+// every priority gets part of a batch proportional to its weight, so low priorities are not starved,
+// part left unused by a priority is given to others and ties are served to higher priorities first.
+// Priorities without weight share a lane of weight 1, weights below 1 are raised to 1.
+func WithPriorityFetching(weights PriorityWeights) RepositoryOption {
+	return func(r *Repository) {
+		r.priorities = weights
+	}
+}
+
+// lanes returns priorities and their weights ordered by priority
+func (w PriorityWeights) lanes() ([]int, []int) {
+	priorities := make([]int, 0, len(w))
+	for p := range w {
+		priorities = append(priorities, p)
+	}
+	sort.Ints(priorities)
+
+	weights := make([]int, 0, len(priorities))
+	for _, p := range priorities {
+		weight := w[p]
+		if weight < 1 {
+			weight = 1
+		}
+		weights = append(weights, weight)
+	}
+
+	return priorities, weights
+}
+
+// priorityFetchQuery selects up to a batch of due messages of every priority lane and orders them
+// by position in lane divided by lane weight, which is the order of weighted fair queueing.
+// Messages created within the same second are ordered by id.
+func priorityFetchQuery(weights PriorityWeights) (string, []any) {
+	priorities, laneWeights := weights.lanes()
+
+	query := fmt.Sprintf(`
+SELECT %[1]s FROM (
+    SELECT lane_messages.*, lane.priority AS lane_priority, lane.weight AS lane_weight
+    FROM unnest($3::int[], $4::int[]) AS lane(priority, weight)
+    CROSS JOIN LATERAL (
+        SELECT * FROM %[2]s
+        WHERE consumed = $1 AND (publish_at IS NULL OR publish_at <= now()) AND priority = lane.priority
+        ORDER BY created_at ASC, id ASC LIMIT $2
+    ) lane_messages
+    UNION ALL
+    SELECT other.*, NULL, 1 FROM (
+        SELECT * FROM %[2]s
+        WHERE consumed = $1 AND (publish_at IS NULL OR publish_at <= now()) AND priority <> ALL($3::int[])
+        ORDER BY created_at ASC, id ASC LIMIT $2
+    ) other
+) due
+ORDER BY row_number() OVER (PARTITION BY lane_priority ORDER BY created_at ASC, id ASC)::float8 / lane_weight ASC,
+         priority DESC, created_at ASC, id ASC
+LIMIT $2
+`, fetchColumns, TableName)
+
+	return query, []any{priorities, laneWeights}
+}
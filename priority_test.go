@@ -0,0 +1,32 @@
+package outbox
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriority(t *testing.T) {
+	t.Run("Test priorities out of smallint range are rejected", func(t *testing.T) {
+		cfg := newPersisterConfig(nil)
+
+		for _, priority := range []int{math.MinInt16, 0, math.MaxInt16} {
+			msg := Message{ID: "1", Payload: `{}`, Priority: priority}
+			assert.NoError(t, cfg.prepare(context.Background(), &msg))
+		}
+
+		for _, priority := range []int{math.MinInt16 - 1, math.MaxInt16 + 1} {
+			msg := Message{ID: "1", Payload: `{}`, Priority: priority}
+			assert.ErrorIs(t, cfg.prepare(context.Background(), &msg), ErrPriorityOutOfRange)
+		}
+	})
+
+	t.Run("Test lanes are ordered by priority", func(t *testing.T) {
+		priorities, weights := PriorityWeights{10: 8, 0: 0, 5: 2}.lanes()
+
+		assert.Equal(t, []int{0, 5, 10}, priorities)
+		assert.Equal(t, []int{1, 2, 8}, weights)
+	})
+}
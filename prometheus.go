@@ -0,0 +1,175 @@
+package outbox
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusMetrics implements Metrics and prometheus.Collector,
+// register it with prometheus.Registerer to expose relay metrics
+type PrometheusMetrics struct {
+	fetched            prometheus.Counter
+	published          *prometheus.CounterVec
+	publishFailed      *prometheus.CounterVec
+	publishLatency     *prometheus.HistogramVec
+	expired            *prometheus.CounterVec
+	markedConsumed     prometheus.Counter
+	markConsumedFailed prometheus.Counter
+	markExpiredFailed  prometheus.Counter
+	batchDuration      prometheus.Histogram
+	backlogSize        prometheus.Gauge
+	oldestMessageAge   prometheus.Gauge
+	circuitState       prometheus.Gauge
+}
+
+func NewPrometheusMetrics(namespace string) *PrometheusMetrics {
+	return &PrometheusMetrics{
+		fetched: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "outbox",
+			Name:      "messages_fetched_total",
+			Help:      "Number of messages fetched from outbox.",
+		}),
+		published: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "outbox",
+			Name:      "messages_published_total",
+			Help:      "Number of messages published.",
+		}, []string{"exchange"}),
+		publishFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "outbox",
+			Name:      "messages_publish_failed_total",
+			Help:      "Number of messages failed to publish.",
+		}, []string{"exchange"}),
+		publishLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "outbox",
+			Name:      "publish_duration_seconds",
+			Help:      "Message publish latency including retries.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"exchange", "status"}),
+		expired: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "outbox",
+			Name:      "messages_expired_total",
+			Help:      "Number of expired messages skipped without publishing.",
+		}, []string{"exchange"}),
+		markedConsumed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "outbox",
+			Name:      "messages_marked_consumed_total",
+			Help:      "Number of messages marked consumed.",
+		}),
+		markConsumedFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "outbox",
+			Name:      "messages_mark_consumed_failed_total",
+			Help:      "Number of published messages failed to be marked consumed.",
+		}),
+		markExpiredFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "outbox",
+			Name:      "messages_mark_expired_failed_total",
+			Help:      "Number of expired messages failed to be marked expired.",
+		}),
+		batchDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "outbox",
+			Name:      "batch_duration_seconds",
+			Help:      "Duration of fetch, publish and mark consumed of a batch.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		backlogSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "outbox",
+			Name:      "backlog_messages",
+			Help:      "Number of unconsumed messages.",
+		}),
+		oldestMessageAge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "outbox",
+			Name:      "oldest_unconsumed_message_age_seconds",
+			Help:      "Age of the oldest unconsumed message.",
+		}),
+		circuitState: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "outbox",
+			Name:      "circuit_state",
+			Help:      "Publisher circuit breaker state: 0 closed, 1 open, 2 half-open.",
+		}),
+	}
+}
+
+func (m *PrometheusMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.fetched,
+		m.published,
+		m.publishFailed,
+		m.publishLatency,
+		m.expired,
+		m.markedConsumed,
+		m.markConsumedFailed,
+		m.markExpiredFailed,
+		m.batchDuration,
+		m.backlogSize,
+		m.oldestMessageAge,
+		m.circuitState,
+	}
+}
+
+func (m *PrometheusMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+func (m *PrometheusMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func (m *PrometheusMetrics) MessagesFetched(n int) {
+	m.fetched.Add(float64(n))
+}
+
+func (m *PrometheusMetrics) MessagePublished(exchange string, latency time.Duration) {
+	m.published.WithLabelValues(exchange).Inc()
+	m.publishLatency.WithLabelValues(exchange, "success").Observe(latency.Seconds())
+}
+
+func (m *PrometheusMetrics) MessagePublishFailed(exchange string, latency time.Duration) {
+	m.publishFailed.WithLabelValues(exchange).Inc()
+	m.publishLatency.WithLabelValues(exchange, "failure").Observe(latency.Seconds())
+}
+
+func (m *PrometheusMetrics) MessageExpired(exchange string) {
+	m.expired.WithLabelValues(exchange).Inc()
+}
+
+func (m *PrometheusMetrics) MessagesMarkedConsumed(n int) {
+	m.markedConsumed.Add(float64(n))
+}
+
+func (m *PrometheusMetrics) MarkConsumedFailed(n int) {
+	m.markConsumedFailed.Add(float64(n))
+}
+
+func (m *PrometheusMetrics) MarkExpiredFailed(n int) {
+	m.markExpiredFailed.Add(float64(n))
+}
+
+func (m *PrometheusMetrics) BatchProcessed(duration time.Duration) {
+	m.batchDuration.Observe(duration.Seconds())
+}
+
+func (m *PrometheusMetrics) Backlog(stats BacklogStats) {
+	m.backlogSize.Set(float64(stats.Size))
+	m.oldestMessageAge.Set(stats.OldestAge(time.Now()).Seconds())
+}
+
+func (m *PrometheusMetrics) CircuitStateChanged(state CircuitState) {
+	m.circuitState.Set(float64(state))
+}
@@ -0,0 +1,32 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	m := NewPrometheusMetrics("test")
+	reg := prometheus.NewPedanticRegistry()
+	assert.NoError(t, reg.Register(m))
+
+	m.MessagesFetched(3)
+	m.MessagePublished("orders", time.Millisecond)
+	m.MessagePublished("orders", time.Millisecond)
+	m.MessagePublishFailed("payments", time.Millisecond)
+	m.MessagesMarkedConsumed(2)
+	m.MarkExpiredFailed(1)
+	m.Backlog(BacklogStats{Size: 5, OldestDueAt: time.Now().Add(-time.Minute)})
+
+	assert.Equal(t, float64(3), testutil.ToFloat64(m.fetched))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.published.WithLabelValues("orders")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.publishFailed.WithLabelValues("payments")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.markedConsumed))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.markExpiredFailed))
+	assert.Equal(t, float64(5), testutil.ToFloat64(m.backlogSize))
+	assert.GreaterOrEqual(t, testutil.ToFloat64(m.oldestMessageAge), float64(60))
+}
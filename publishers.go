@@ -0,0 +1,142 @@
+package outbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// PublishedMessage is a JSON representation of a message written by WriterPublisher
+// and sent by HTTPPublisher
+type PublishedMessage struct {
+	ID           string          `json:"id"`
+	EventType    string          `json:"event_type"`
+	Exchange     string          `json:"exchange"`
+	RoutingKey   string          `json:"routing_key"`
+	PartitionKey *int64          `json:"partition_key,omitempty"`
+	Headers      Headers         `json:"headers,omitempty"`
+	ContentType  string          `json:"content_type"`
+	Payload      json.RawMessage `json:"payload"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+func newPublishedMessage(exchange, topic string, message Message) (PublishedMessage, error) {
+	payload, err := jsonPayload(message)
+	if err != nil {
+		return PublishedMessage{}, err
+	}
+
+	m := PublishedMessage{
+		ID:          message.ID,
+		EventType:   message.EventType,
+		Exchange:    exchange,
+		RoutingKey:  topic,
+		Headers:     message.Headers,
+		ContentType: message.ContentType(),
+		Payload:     payload,
+		CreatedAt:   message.CreatedAt,
+	}
+	if message.PartitionKey.Valid {
+		m.PartitionKey = &message.PartitionKey.Int64
+	}
+
+	return m, nil
+}
+
+// jsonPayload returns JSON payload as is, payloads of other codecs are encoded as base64 strings
+func jsonPayload(message Message) (json.RawMessage, error) {
+	b, err := message.plainPayload()
+	if err != nil {
+		return nil, fmt.Errorf("while encoding payload of message %s: %w", message.ID, err)
+	}
+
+	if (message.Codec == "" || message.Codec == CodecJSON) && json.Valid(b) {
+		return b, nil
+	}
+
+	return json.Marshal(b)
+}
+
+// WriterPublisher writes messages to w as JSON lines, it is useful for debugging
+// and for piping messages to other tools
+type WriterPublisher struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+func NewWriterPublisher(w io.Writer) *WriterPublisher {
+	return &WriterPublisher{w: w}
+}
+
+func (p *WriterPublisher) Publish(exchange, topic string, message Message) error {
+	m, err := newPublishedMessage(exchange, topic, message)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("while encoding message %s: %w", message.ID, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err = p.w.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("while writing message %s: %w", message.ID, err)
+	}
+
+	return nil
+}
+
+// HTTPPublisher posts every message as JSON to an endpoint,
+// responses with status other than 2xx are treated as failures
+type HTTPPublisher struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPPublisher(url string, client *http.Client) *HTTPPublisher {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &HTTPPublisher{url: url, client: client}
+}
+
+func (p *HTTPPublisher) Publish(exchange, topic string, message Message) error {
+	m, err := newPublishedMessage(exchange, topic, message)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("while encoding message %s: %w", message.ID, err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("while creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Outbox-Message-Id", message.ID)
+	req.Header.Set("X-Outbox-Event-Type", message.EventType)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("while sending message %s: %w", message.ID, err)
+	}
+	defer resp.Body.Close() //nolint
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("sending message %s failed: unexpected status %d", message.ID, resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package outbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterPublisher_Publish(t *testing.T) {
+	buf := &bytes.Buffer{}
+	p := NewWriterPublisher(buf)
+
+	require.NoError(t, p.Publish("ex", "rk", Message{ID: "1", EventType: "Created", Payload: []byte(`{"a":1}`)}))
+	require.NoError(t, p.Publish("ex", "rk", Message{ID: "2", EventType: "Created", Payload: map[string]any{"b": 2}}))
+	require.NoError(t, p.Publish("ex", "rk", Message{ID: "3", EventType: "Created", Payload: map[string]any{"c": 3}, Codec: CodecMessagePack}))
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 3)
+
+	payloads := make([]string, 0, len(lines))
+	contentTypes := make([]string, 0, len(lines))
+	for _, line := range lines {
+		var m PublishedMessage
+		require.NoError(t, json.Unmarshal(line, &m))
+		assert.Equal(t, "ex", m.Exchange)
+		assert.Equal(t, "rk", m.RoutingKey)
+		payloads = append(payloads, string(m.Payload))
+		contentTypes = append(contentTypes, m.ContentType)
+	}
+
+	assert.Equal(t, []string{`{"a":1}`, `{"b":2}`, `"gaFjAw=="`}, payloads)
+	assert.Equal(t, []string{"application/json", "application/json", "application/msgpack"}, contentTypes)
+}
+
+func TestHTTPPublisher_Publish(t *testing.T) {
+	var received PublishedMessage
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "1", r.Header.Get("X-Outbox-Message-Id"))
+
+		b, _ := io.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(b, &received))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	p := NewHTTPPublisher(server.URL, nil)
+	msg := Message{ID: "1", EventType: "Created", Payload: []byte(`{"a":1}`), Headers: Headers{"k": "v"}}
+
+	require.NoError(t, p.Publish("ex", "rk", msg))
+	assert.Equal(t, "Created", received.EventType)
+	assert.Equal(t, Headers{"k": "v"}, received.Headers)
+	assert.JSONEq(t, `{"a":1}`, string(received.Payload))
+
+	status = http.StatusInternalServerError
+	assert.Error(t, p.Publish("ex", "rk", msg))
+}
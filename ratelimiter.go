@@ -0,0 +1,141 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type routingKeyLimit struct {
+	exchange   string
+	routingKey string
+}
+
+// RateLimiter limits publish rate with token buckets globally, per exchange and per routing key.
+// Relay waits for tokens of every matching bucket before publishing a message,
+// so throttled partitions are slowed down rather than failed.
+type RateLimiter struct {
+	global      *rate.Limiter
+	exchanges   map[string]*rate.Limiter
+	routingKeys map[routingKeyLimit]*rate.Limiter
+	mu          sync.RWMutex
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		exchanges:   make(map[string]*rate.Limiter),
+		routingKeys: make(map[routingKeyLimit]*rate.Limiter),
+	}
+}
+
+// SetGlobalLimit limits publishing of all messages to perSecond with burst, it panics when burst is not positive
+func (l *RateLimiter) SetGlobalLimit(perSecond float64, burst int) *RateLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.global = newLimiter(perSecond, burst)
+
+	return l
+}
+
+// SetExchangeLimit limits publishing of messages to exchange to perSecond with burst,
+// it panics when burst is not positive
+func (l *RateLimiter) SetExchangeLimit(exchange string, perSecond float64, burst int) *RateLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.exchanges[exchange] = newLimiter(perSecond, burst)
+
+	return l
+}
+
+// SetRoutingKeyLimit limits publishing of messages to exchange with routingKey to perSecond with burst,
+// it panics when burst is not positive
+func (l *RateLimiter) SetRoutingKeyLimit(exchange, routingKey string, perSecond float64, burst int) *RateLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.routingKeys[routingKeyLimit{exchange: exchange, routingKey: routingKey}] = newLimiter(perSecond, burst)
+
+	return l
+}
+
+// Wait blocks until message may be published or ctx is done
+func (l *RateLimiter) Wait(ctx context.Context, message Message) error {
+	for _, limiter := range l.limiters(message) {
+		if err := wait(ctx, limiter); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// wait blocks until limiter grants a token or ctx is done. Unlike rate.Limiter.Wait it does not fail
+// right away when the token would be granted after ctx deadline, so throttled batches wait until their deadline.
+func wait(ctx context.Context, limiter *rate.Limiter) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	reservation := limiter.Reserve()
+	delay := reservation.Delay()
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		reservation.Cancel()
+		return ctx.Err()
+	}
+}
+
+func newLimiter(perSecond float64, burst int) *rate.Limiter {
+	if burst < 1 {
+		panic(fmt.Sprintf("outbox: rate limit burst must be positive, got %d", burst))
+	}
+
+	return rate.NewLimiter(rate.Limit(perSecond), burst)
+}
+
+// Middleware waits for tokens before publishing, waiting stops when context of message is done.
+// Use it to throttle publishers used outside of relay, relay is throttled with WithRateLimiter.
+func (l *RateLimiter) Middleware() PublisherMiddleware {
+	return func(next Publisher) Publisher {
+		return PublisherFunc(func(exchange, topic string, message Message) error {
+			if err := l.Wait(message.Context(), message); err != nil {
+				return err
+			}
+
+			return next.Publish(exchange, topic, message)
+		})
+	}
+}
+
+// limiters returns buckets applying to message from the most specific to global
+func (l *RateLimiter) limiters(message Message) []*rate.Limiter {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	limiters := make([]*rate.Limiter, 0, 3)
+	if limiter, ok := l.routingKeys[routingKeyLimit{exchange: message.Exchange, routingKey: message.RoutingKey}]; ok {
+		limiters = append(limiters, limiter)
+	}
+	if limiter, ok := l.exchanges[message.Exchange]; ok {
+		limiters = append(limiters, limiter)
+	}
+	if l.global != nil {
+		limiters = append(limiters, l.global)
+	}
+
+	return limiters
+}
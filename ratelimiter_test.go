@@ -0,0 +1,94 @@
+package outbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_Wait(t *testing.T) {
+	t.Run("Test exchange limit applies backpressure", func(t *testing.T) {
+		l := NewRateLimiter().SetExchangeLimit("orders", 100, 1)
+
+		start := time.Now()
+		for i := 0; i < 6; i++ {
+			assert.NoError(t, l.Wait(context.Background(), Message{Exchange: "orders"}))
+		}
+
+		assert.GreaterOrEqual(t, time.Since(start), 45*time.Millisecond)
+	})
+
+	t.Run("Test unlimited exchange is not throttled", func(t *testing.T) {
+		l := NewRateLimiter().SetExchangeLimit("orders", 1, 1).SetRoutingKeyLimit("users", "created", 1, 1)
+
+		start := time.Now()
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, l.Wait(context.Background(), Message{Exchange: "users", RoutingKey: "deleted"}))
+		}
+
+		assert.Less(t, time.Since(start), 10*time.Millisecond)
+	})
+
+	t.Run("Test wait blocks until context is done", func(t *testing.T) {
+		l := NewRateLimiter().SetGlobalLimit(0.1, 1)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+		defer cancel()
+
+		assert.NoError(t, l.Wait(ctx, Message{}))
+
+		start := time.Now()
+		assert.ErrorIs(t, l.Wait(ctx, Message{}), context.DeadlineExceeded)
+		assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	})
+
+	t.Run("Test wait past context deadline is granted token", func(t *testing.T) {
+		l := NewRateLimiter().SetGlobalLimit(20, 1)
+		assert.NoError(t, l.Wait(context.Background(), Message{}))
+
+		// token is granted in 50ms, after the first deadline, wait is cancelled without consuming it
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, l.Wait(ctx, Message{}), context.DeadlineExceeded)
+
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		assert.NoError(t, l.Wait(ctx, Message{}))
+	})
+
+	t.Run("Test burst must be positive", func(t *testing.T) {
+		assert.Panics(t, func() {
+			NewRateLimiter().SetGlobalLimit(10, 0)
+		})
+		assert.Panics(t, func() {
+			NewRateLimiter().SetExchangeLimit("orders", 10, 0)
+		})
+	})
+}
+
+func TestRelay_RateLimiter(t *testing.T) {
+	t.Run("Test throttled batch ends at deadline without failures", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		r := &failureRecorderMock{RepositoryMock: RepositoryMock{Messages: GenerateMessages(10)}}
+		p := &PublisherMock{}
+		l := NewRateLimiter().SetGlobalLimit(20, 1)
+
+		var errs []error
+		handler := func(ctx context.Context, err error) {
+			errs = append(errs, err)
+		}
+
+		relay := NewRelay(r, p, 1, time.Hour, WithRateLimiter(l), WithBatchTimeout(120*time.Millisecond),
+			WithErrorHandler(handler), WithLogger(NopLogger{}))
+		assert.NoError(t, relay.Run(ctx, BatchSize(10)))
+
+		assert.NotEmpty(t, p.Published)
+		assert.Less(t, len(p.Published), 10)
+		assert.Equal(t, len(p.Published), len(r.Consumed))
+		assert.Empty(t, errs)
+		assert.Empty(t, r.failures)
+	})
+}
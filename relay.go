@@ -2,21 +2,120 @@ package outbox
 
 import (
 	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/avast/retry-go"
-	"github.com/rs/zerolog/log"
 	concurrency "github.com/vsvp21/go-concurrency"
 )
 
-func NewRelay(repo EventRepository, publisher Publisher, partitions int, publishDelay time.Duration) *Relay {
-	return &Relay{
+const (
+	defaultBatchTimeout = 30 * time.Second
+	// markConsumedTimeout bounds marking published messages consumed after batch context is done
+	markConsumedTimeout = 10 * time.Second
+)
+
+type RelayOption func(r *Relay)
+
+// WithMetrics sets metrics receiver of relay pipeline
+func WithMetrics(m Metrics) RelayOption {
+	return func(r *Relay) {
+		r.metrics = m
+	}
+}
+
+// WithLogger sets logger of relay, zerolog global logger is used by default
+func WithLogger(l Logger) RelayOption {
+	return func(r *Relay) {
+		r.logger = l
+	}
+}
+
+// WithHooks sets hooks invoked on relay pipeline stages
+func WithHooks(h Hooks) RelayOption {
+	return func(r *Relay) {
+		r.hooks = h
+	}
+}
+
+// WithPublisherMiddlewares wraps publisher with middlewares, the first middleware is the outermost.
+// It replaces the default retry middleware configured by PublishRetryAttempts and PublishRetryDelay,
+// include RetryMiddleware to keep retrying. Middlewares get batch context with Message.Context.
+// Publishing is traced by relay and payloads are encrypted on persist, so there are no middlewares for them.
+func WithPublisherMiddlewares(middlewares ...PublisherMiddleware) RelayOption {
+	return func(r *Relay) {
+		r.middlewares = middlewares
+	}
+}
+
+// WithCircuitBreaker wraps publisher with circuit breaker as the innermost middleware,
+// so every publish attempt is counted, relay does not fetch messages while breaker is open.
+// Messages rejected by open breaker are not counted as publish failures.
+func WithCircuitBreaker(cb *CircuitBreaker) RelayOption {
+	return func(r *Relay) {
+		r.breaker = cb
+	}
+}
+
+// WithRateLimiter throttles publishing of partition workers
+func WithRateLimiter(l *RateLimiter) RelayOption {
+	return func(r *Relay) {
+		r.limiter = l
+	}
+}
+
+// WithBatchTimeout sets deadline of fetching and publishing a batch, 30 seconds by default.
+// Non-positive timeouts are ignored.
+func WithBatchTimeout(d time.Duration) RelayOption {
+	return func(r *Relay) {
+		if d > 0 {
+			r.batchTimeout = d
+		}
+	}
+}
+
+// WithErrorPolicy sets policy deciding which errors are fatal, no errors are fatal by default.
+// Fatal error cancels the batch, messages published so far are marked consumed and Run returns the error.
+func WithErrorPolicy(p ErrorPolicy) RelayOption {
+	return func(r *Relay) {
+		r.errorPolicy = p
+	}
+}
+
+// WithErrorHandler sets handler of transient errors, errors are *FetchError, *PublishError, *MarkConsumedError
+// or *MarkExpiredError
+func WithErrorHandler(h ErrorHandler) RelayOption {
+	return func(r *Relay) {
+		r.errorHandler = h
+	}
+}
+
+func NewRelay(repo EventRepository, publisher Publisher, partitions int, publishDelay time.Duration, opts ...RelayOption) *Relay {
+	r := &Relay{
 		eventRepository: repo,
 		publisher:       publisher,
 		delay:           publishDelay,
 		partitions:      partitions,
+		metrics:         NopMetrics{},
+		logger:          defaultLogger(),
+		middlewares:     []PublisherMiddleware{defaultRetryMiddleware()},
+		batchTimeout:    defaultBatchTimeout,
+		stop:            make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(r)
 	}
+
+	middlewares := r.middlewares
+	if r.breaker != nil {
+		middlewares = append(append([]PublisherMiddleware{}, middlewares...), r.breaker.Middleware())
+		r.breaker.OnStateChange(r.circuitStateChanged)
+	}
+	r.publisher = ChainPublisher(publisher, middlewares...)
+
+	return r
 }
 
 type Relay struct {
@@ -24,6 +123,26 @@ type Relay struct {
 	publisher       Publisher
 	delay           time.Duration
 	partitions      int
+	metrics         Metrics
+	logger          Logger
+	hooks           Hooks
+	middlewares     []PublisherMiddleware
+	breaker         *CircuitBreaker
+	limiter         *RateLimiter
+	batchTimeout    time.Duration
+	errorPolicy     ErrorPolicy
+	errorHandler    ErrorHandler
+	batchSeq        uint64
+	fatalErr        error
+	health          relayHealth
+	// healthBacklog is set once health checks need backlog
+	healthBacklog atomic.Bool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	abort    context.CancelFunc
+	done     chan struct{}
+	mu       sync.Mutex
 }
 
 func (r *Relay) Run(ctx context.Context, batchSize BatchSize) error {
@@ -31,56 +150,173 @@ func (r *Relay) Run(ctx context.Context, batchSize BatchSize) error {
 		return err
 	}
 
+	runCtx, abort := context.WithCancel(ctx)
+	defer abort()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	r.mu.Lock()
+	r.abort, r.done = abort, done
+	r.mu.Unlock()
+
+	r.health.setRunning(true)
+	defer r.health.setRunning(false)
+
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
+		case <-r.stop:
+			return nil
 		default:
 		}
 
-		batchCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-		func() {
-			defer cancel()
-			messagesStream := r.eventRepository.Fetch(batchCtx, batchSize)
-			partitionedMessagesStreams := partitionedFanOut(batchCtx, messagesStream, r.partitions)
-			publishStream := fanInPublish(batchCtx, r.publisher, partitionedMessagesStreams)
-			markConsumed(batchCtx, r.eventRepository, publishStream, batchSize)
-		}()
+		r.health.iterated()
+		if r.breaker == nil || r.breaker.State() != CircuitOpen {
+			r.processBatch(runCtx, batchSize)
+		}
+
+		if err := r.fatal(); err != nil {
+			return err
+		}
 
 		select {
 		case <-ctx.Done():
 			return nil
+		case <-r.stop:
+			return nil
 		case <-time.After(r.delay):
 		}
 	}
 }
 
-func partitionedFanOut(ctx context.Context, ch <-chan Message, n int) []chan Message {
-	cs := make([]chan Message, n)
-	for i := 0; i < n; i++ {
+// Shutdown stops fetching new messages, waits for in-flight messages to be published
+// and marked consumed and for Run to return. When ctx is done first, publishing is cancelled,
+// messages published so far are still marked consumed, and ctx error is returned.
+func (r *Relay) Shutdown(ctx context.Context) error {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+
+	r.mu.Lock()
+	abort, done := r.abort, r.done
+	r.mu.Unlock()
+
+	if done == nil {
+		return nil
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		abort()
+		return ctx.Err()
+	}
+}
+
+// processBatch fetches, publishes and marks consumed a batch of messages.
+//
+// Fetching stops when ctx is done or Shutdown is called, publishing stops when ctx is done,
+// messages already published are marked consumed even if ctx is done.
+func (r *Relay) processBatch(ctx context.Context, batchSize BatchSize) {
+	start := time.Now()
+	defer r.health.batchStarted()()
+
+	ctx = contextWithBatchID(ctx, atomic.AddUint64(&r.batchSeq, 1))
+	publishCtx, cancel := context.WithTimeout(ctx, r.batchTimeout)
+	defer cancel()
+
+	fetchCtx, cancelFetch := context.WithCancel(publishCtx)
+	defer cancelFetch()
+	go func() {
+		select {
+		case <-r.stop:
+			cancelFetch()
+		case <-fetchCtx.Done():
+		}
+	}()
+
+	messagesStream, fetched := r.fetch(fetchCtx, batchSize)
+	partitionedMessagesStreams := r.partitionedFanOut(fetchCtx, messagesStream)
+	publishStream := r.fanInPublish(publishCtx, partitionedMessagesStreams)
+
+	markCtx, cancelMark := context.WithTimeout(withoutCancel(ctx), markConsumedTimeout)
+	defer cancelMark()
+	r.markConsumed(markCtx, publishStream, batchSize)
+
+	// fetch errors are accounted to this batch before it completes,
+	// messages left unread after partitioning stopped are fetched again with the next batch
+	cancelFetch()
+	for range messagesStream {
+	}
+	<-fetched
+
+	r.metrics.BatchProcessed(time.Since(start))
+	r.reportBacklog(markCtx)
+}
+
+// fetch fetches messages reporting fetch errors when repository implements FetchErrorReporter,
+// returned done channel is closed once all fetch errors are reported
+func (r *Relay) fetch(ctx context.Context, batchSize BatchSize) (<-chan Message, <-chan struct{}) {
+	done := make(chan struct{})
+
+	reporter, ok := r.eventRepository.(FetchErrorReporter)
+	if !ok {
+		// repository can not report failures, so every fetch is considered successful
+		r.health.fetched()
+		close(done)
+		return r.eventRepository.Fetch(ctx, batchSize), done
+	}
+
+	stream, errs := reporter.FetchWithErrors(ctx, batchSize)
+	go func() {
+		defer close(done)
+
+		failed := false
+		for err := range errs {
+			failed = true
+			r.logger.Error("while fetching messages", err, batchFields(ctx)...)
+			r.reportError(ctx, &FetchError{Err: err})
+		}
+
+		if !failed {
+			r.health.fetched()
+		}
+	}()
+
+	return stream, done
+}
+
+func (r *Relay) partitionedFanOut(ctx context.Context, ch <-chan Message) []chan Message {
+	cs := make([]chan Message, r.partitions)
+	for i := 0; i < r.partitions; i++ {
 		cs[i] = make(chan Message, 1000)
 	}
 
 	go func() {
+		fetched := make([]Message, 0)
 		defer func() {
+			r.metrics.MessagesFetched(len(fetched))
+			r.hooks.batchFetched(ctx, fetched)
 			for _, c := range cs {
 				close(c)
 			}
 		}()
 
 		for msg := range concurrency.OrDone[Message](ctx, ch) {
+			fetched = append(fetched, msg)
 			partitionIdx := int(msg.PartitionKey.Int64) % len(cs)
 
 			select {
 			case cs[partitionIdx] <- msg:
 			case <-ctx.Done():
-				log.Info().Msg("context cancelled while partitioning messages")
+				r.logger.Info("context cancelled while partitioning messages", batchFields(ctx)...)
 				return
 			default:
-				log.Info().
-					Int("partition", partitionIdx).
-					Str("message_id", msg.ID).
-					Msg("partition channel full, dropping message")
+				r.logger.Info("partition channel full, dropping message", append(messageFields(ctx, msg), LogField("partition", partitionIdx))...)
+				r.hooks.drop(ctx, msg, partitionIdx)
 			}
 		}
 	}()
@@ -88,36 +324,55 @@ func partitionedFanOut(ctx context.Context, ch <-chan Message, n int) []chan Mes
 	return cs
 }
 
-func fanInPublish(ctx context.Context, publisher Publisher, cs []chan Message) <-chan Message {
-	fanInCh := make(chan Message, 1000)
+// processedMessage is a message published or skipped as expired by partition worker
+type processedMessage struct {
+	Message
+	expired bool
+}
+
+func (r *Relay) fanInPublish(ctx context.Context, cs []chan Message) <-chan processedMessage {
+	fanInCh := make(chan processedMessage, 1000)
 
 	go func() {
 		defer close(fanInCh)
 		wg := sync.WaitGroup{}
 		wg.Add(len(cs))
 
-		for _, ch := range cs {
-			go func(ch <-chan Message) {
+		for i, ch := range cs {
+			go func(partition int, ch <-chan Message) {
 				defer wg.Done()
 				for msg := range concurrency.OrDone[Message](ctx, ch) {
-					publish := func() error {
-						return publisher.Publish(msg.Exchange, msg.RoutingKey, msg)
+					if msg.Expired(time.Now()) {
+						r.logger.Debug("skipping expired message", messageFields(ctx, msg)...)
+						r.metrics.MessageExpired(msg.Exchange)
+						fanInCh <- processedMessage{Message: msg, expired: true}
+						continue
 					}
 
-					err := retry.Do(publish, retry.Delay(PublishRetryDelay), retry.Attempts(PublishRetryAttempts), retry.Context(ctx))
-					if err != nil {
-						log.Error().Err(err).Msg("while publishing message")
+					err := r.publish(ctx, &msg)
+					if errors.Is(err, ErrVetoed) {
+						continue
+					}
+					if errors.Is(err, ErrCircuitOpen) {
+						// message never reached publisher, it is retried once breaker closes
+						r.logger.Debug("circuit breaker is open, stopping partition", append(messageFields(ctx, msg), LogField("partition", partition))...)
 						return
 					}
-
-					select {
-					case fanInCh <- msg:
-					case <-ctx.Done():
-						log.Warn().Msg("context cancelled while trying to send published message")
+					if err != nil && ctx.Err() != nil {
+						// batch ended while waiting for rate limiter or retrying, message is published with the next batch
+						r.logger.Info("batch context done, stopping partition", append(messageFields(ctx, msg), LogField("partition", partition))...)
 						return
 					}
+					if err != nil {
+						r.logger.Error("while publishing message", err, append(messageFields(ctx, msg), LogField("partition", partition))...)
+						r.recordFailure(ctx, msg, err)
+						r.reportError(ctx, &PublishError{Message: msg, Err: err})
+						return
+					}
+
+					fanInCh <- processedMessage{Message: msg}
 				}
-			}(ch)
+			}(i, ch)
 		}
 
 		wg.Wait()
@@ -126,20 +381,158 @@ func fanInPublish(ctx context.Context, publisher Publisher, cs []chan Message) <
 	return fanInCh
 }
 
-func markConsumed(ctx context.Context, eventRepository EventRepository, ch <-chan Message, batchSize BatchSize) {
+// publish publishes message through middlewares, messages skipped by hooks are not published
+func (r *Relay) publish(ctx context.Context, msg *Message) error {
+	if err := r.hooks.beforePublish(ctx, msg); err != nil {
+		if errors.Is(err, ErrSkipMessage) {
+			return nil
+		}
+
+		r.logger.Debug("message publishing vetoed", append(messageFields(ctx, *msg), LogField("reason", err.Error()))...)
+		return ErrVetoed
+	}
+
+	if r.limiter != nil {
+		if err := r.limiter.Wait(ctx, *msg); err != nil {
+			return err
+		}
+	}
+
+	spanCtx, span := startPublishSpan(ctx, *msg)
+	injectTraceContext(spanCtx, msg)
+
+	start := time.Now()
+	err := r.publisher.Publish(msg.Exchange, msg.RoutingKey, msg.WithContext(spanCtx))
+	endSpan(span, err)
+	r.hooks.afterPublish(ctx, *msg, err)
+	if errors.Is(err, ErrCircuitOpen) {
+		return err
+	}
+	if err != nil {
+		r.metrics.MessagePublishFailed(msg.Exchange, time.Since(start))
+		return err
+	}
+	r.metrics.MessagePublished(msg.Exchange, time.Since(start))
+	r.health.published()
+
+	return nil
+}
+
+func (r *Relay) markConsumed(ctx context.Context, ch <-chan processedMessage, batchSize BatchSize) {
 	msgs := make([]Message, 0, batchSize)
+	expired := make([]Message, 0)
+
+	// drain until every partition worker finished, so that all published messages are marked
+	for msg := range ch {
+		if msg.expired {
+			expired = append(expired, msg.Message)
+			continue
+		}
+		msgs = append(msgs, msg.Message)
+	}
+
+	// repositories not recording expired messages mark them consumed
+	if recorder, ok := r.eventRepository.(ExpirationRecorder); ok {
+		r.markExpired(ctx, recorder, expired)
+	} else {
+		msgs = append(msgs, expired...)
+	}
+
+	if len(msgs) == 0 {
+		return
+	}
 
-	for msg := range concurrency.OrDone[Message](ctx, ch) {
-		msgs = append(msgs, msg)
+	if err := r.eventRepository.MarkConsumed(ctx, msgs); err != nil {
+		r.metrics.MarkConsumedFailed(len(msgs))
+		r.hooks.markConsumedError(ctx, msgs, err)
+		r.logger.Error("failed to mark messages as consumed - messages will be reprocessed", err,
+			append(batchFields(ctx), LogField("message_count", len(msgs)))...)
+		r.reportError(ctx, &MarkConsumedError{Messages: msgs, Err: err})
+		return
 	}
 
+	r.metrics.MessagesMarkedConsumed(len(msgs))
+}
+
+func (r *Relay) markExpired(ctx context.Context, recorder ExpirationRecorder, msgs []Message) {
 	if len(msgs) == 0 {
 		return
 	}
 
-	if err := eventRepository.MarkConsumed(ctx, msgs); err != nil {
-		log.Error().Err(err).
-			Int("message_count", len(msgs)).
-			Msg("failed to mark messages as consumed - messages will be reprocessed")
+	if err := recorder.MarkExpired(ctx, msgs); err != nil {
+		r.metrics.MarkExpiredFailed(len(msgs))
+		r.hooks.markConsumedError(ctx, msgs, err)
+		r.logger.Error("failed to mark messages as expired - messages will be reprocessed", err,
+			append(batchFields(ctx), LogField("message_count", len(msgs)))...)
+		r.reportError(ctx, &MarkExpiredError{Messages: msgs, Err: err})
 	}
 }
+
+// reportBacklog collects backlog when it is reported to metrics or checked by health checks
+func (r *Relay) reportBacklog(ctx context.Context) {
+	if _, ok := r.metrics.(NopMetrics); ok && !r.healthBacklog.Load() {
+		return
+	}
+
+	reporter, ok := r.eventRepository.(BacklogReporter)
+	if !ok {
+		return
+	}
+
+	stats, err := reporter.Backlog(ctx)
+	if err != nil {
+		r.logger.Error("while fetching backlog stats", err, batchFields(ctx)...)
+		return
+	}
+
+	r.metrics.Backlog(stats)
+	r.health.backlogReported(stats)
+}
+
+// recordFailure stores publish failure when repository implements FailureRecorder
+func (r *Relay) recordFailure(ctx context.Context, msg Message, err error) {
+	recorder, ok := r.eventRepository.(FailureRecorder)
+	if !ok {
+		return
+	}
+
+	if recordErr := recorder.RecordFailure(ctx, msg, err); recordErr != nil {
+		r.logger.Error("while recording publish failure", recordErr, messageFields(ctx, msg)...)
+	}
+}
+
+// reportError stops Run on fatal errors and passes transient ones to error handler
+func (r *Relay) reportError(ctx context.Context, err error) {
+	r.health.failed()
+
+	if r.errorPolicy != nil && r.errorPolicy(err) {
+		r.mu.Lock()
+		if r.fatalErr == nil {
+			r.fatalErr = err
+		}
+		abort := r.abort
+		r.mu.Unlock()
+
+		if abort != nil {
+			abort()
+		}
+		return
+	}
+
+	if r.errorHandler != nil {
+		r.errorHandler(ctx, err)
+	}
+}
+
+func (r *Relay) fatal() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.fatalErr
+}
+
+func (r *Relay) circuitStateChanged(from, to CircuitState) {
+	r.logger.Warn("publisher circuit breaker state changed", LogField("from", from.String()), LogField("to", to.String()))
+	r.metrics.CircuitStateChanged(to)
+	r.hooks.circuitStateChange(from, to)
+}
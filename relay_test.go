@@ -2,16 +2,20 @@ package outbox
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"runtime"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // publisherMock mocks message publishing
@@ -64,12 +68,7 @@ func TestRelay_Run(t *testing.T) {
 
 		p := &PublisherMock{Published: make([]Message, 0, n)}
 
-		relay := &Relay{
-			eventRepository: r,
-			publisher:       p,
-			delay:           time.Millisecond,
-			partitions:      runtime.NumCPU(),
-		}
+		relay := NewRelay(r, p, runtime.NumCPU(), time.Millisecond)
 
 		if err := relay.Run(ctx, BatchSize(10)); err != nil {
 			log.Fatal(err)
@@ -79,3 +78,356 @@ func TestRelay_Run(t *testing.T) {
 		assert.Equal(t, n, len(r.Consumed))
 	})
 }
+
+// metricsMock counts relay measurements
+type metricsMock struct {
+	NopMetrics
+	fetched, published, consumed int
+	mu                           sync.Mutex
+}
+
+func (m *metricsMock) MessagesFetched(n int) {
+	m.mu.Lock()
+	m.fetched += n
+	m.mu.Unlock()
+}
+
+func (m *metricsMock) MessagePublished(string, time.Duration) {
+	m.mu.Lock()
+	m.published++
+	m.mu.Unlock()
+}
+
+func (m *metricsMock) MessagesMarkedConsumed(n int) {
+	m.mu.Lock()
+	m.consumed += n
+	m.mu.Unlock()
+}
+
+func TestRelay_Metrics(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Millisecond*500)
+	defer cancel()
+
+	n := 10
+	r := &RepositoryMock{Messages: GenerateMessages(n)}
+	m := &metricsMock{}
+
+	relay := NewRelay(r, &PublisherMock{}, runtime.NumCPU(), time.Millisecond, WithMetrics(m))
+	if err := relay.Run(ctx, BatchSize(10)); err != nil {
+		log.Fatal(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	assert.Equal(t, n, m.fetched)
+	assert.Equal(t, n, m.published)
+	assert.Equal(t, n, m.consumed)
+}
+
+// slowPublisherMock publishes messages with delay
+type slowPublisherMock struct {
+	PublisherMock
+	delay time.Duration
+}
+
+func (p *slowPublisherMock) Publish(exchange, topic string, message Message) error {
+	time.Sleep(p.delay)
+	return p.PublisherMock.Publish(exchange, topic, message)
+}
+
+func TestRelay_Shutdown(t *testing.T) {
+	t.Run("Test shutdown drains in-flight batch", func(t *testing.T) {
+		n := 20
+		r := &RepositoryMock{Messages: GenerateMessages(n)}
+		p := &slowPublisherMock{delay: 10 * time.Millisecond}
+
+		relay := NewRelay(r, p, 1, time.Millisecond, WithLogger(NopLogger{}))
+
+		runErr := make(chan error)
+		go func() {
+			runErr <- relay.Run(context.Background(), BatchSize(n))
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		assert.NoError(t, relay.Shutdown(ctx))
+		assert.NoError(t, <-runErr)
+		assert.Equal(t, n, len(p.Published))
+		assert.Equal(t, n, len(r.Consumed))
+	})
+
+	t.Run("Test shutdown grace period expiry cancels publishing", func(t *testing.T) {
+		n := 20
+		r := &RepositoryMock{Messages: GenerateMessages(n)}
+		p := &slowPublisherMock{delay: 10 * time.Millisecond}
+
+		relay := NewRelay(r, p, 1, time.Millisecond, WithLogger(NopLogger{}))
+
+		runErr := make(chan error)
+		go func() {
+			runErr <- relay.Run(context.Background(), BatchSize(n))
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		assert.ErrorIs(t, relay.Shutdown(ctx), context.DeadlineExceeded)
+		assert.NoError(t, <-runErr)
+		assert.Less(t, len(p.Published), n)
+		assert.Equal(t, len(p.Published), len(r.Consumed))
+	})
+
+	t.Run("Test published messages are marked consumed when run context is cancelled", func(t *testing.T) {
+		n := 20
+		r := &RepositoryMock{Messages: GenerateMessages(n)}
+		p := &slowPublisherMock{delay: 10 * time.Millisecond}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		relay := NewRelay(r, p, 1, time.Millisecond, WithLogger(NopLogger{}))
+		assert.NoError(t, relay.Run(ctx, BatchSize(n)))
+
+		assert.NotEmpty(t, p.Published)
+		assert.Equal(t, len(p.Published), len(r.Consumed))
+	})
+}
+
+// fetchErrorRepositoryMock fails fetching
+type fetchErrorRepositoryMock struct {
+	RepositoryMock
+}
+
+func (m *fetchErrorRepositoryMock) FetchWithErrors(ctx context.Context, batchSize BatchSize) (<-chan Message, <-chan error) {
+	stream, errs := make(chan Message), make(chan error, 1)
+	errs <- errors.New("connection refused")
+	close(stream)
+	close(errs)
+
+	return stream, errs
+}
+
+// lateFetchErrorRepositoryMock reports fetch error after messages stream is closed
+type lateFetchErrorRepositoryMock struct {
+	RepositoryMock
+}
+
+func (m *lateFetchErrorRepositoryMock) FetchWithErrors(ctx context.Context, batchSize BatchSize) (<-chan Message, <-chan error) {
+	stream, errs := make(chan Message), make(chan error)
+	close(stream)
+
+	go func() {
+		defer close(errs)
+
+		time.Sleep(20 * time.Millisecond)
+		errs <- errors.New("connection reset")
+	}()
+
+	return stream, errs
+}
+
+func TestRelay_Errors(t *testing.T) {
+	t.Run("Test fetch errors are accounted to their batch", func(t *testing.T) {
+		relay := NewRelay(&lateFetchErrorRepositoryMock{}, &PublisherMock{}, 1, time.Millisecond, WithLogger(NopLogger{}))
+
+		relay.processBatch(context.Background(), BatchSize(10))
+		assert.Equal(t, 1, relay.Health().ConsecutiveFailures)
+	})
+
+	t.Run("Test non-positive batch timeout is ignored", func(t *testing.T) {
+		relay := NewRelay(&RepositoryMock{}, &PublisherMock{}, 1, time.Millisecond, WithBatchTimeout(0))
+		assert.Equal(t, defaultBatchTimeout, relay.batchTimeout)
+	})
+
+	t.Run("Test transient errors are passed to handler", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.TODO(), time.Millisecond*100)
+		defer cancel()
+
+		p := PublisherFunc(func(exchange, topic string, message Message) error {
+			return errors.New("broker down")
+		})
+
+		var (
+			publishErrs []*PublishError
+			mu          sync.Mutex
+		)
+		handler := func(ctx context.Context, err error) {
+			var publishErr *PublishError
+			if errors.As(err, &publishErr) {
+				mu.Lock()
+				publishErrs = append(publishErrs, publishErr)
+				mu.Unlock()
+			}
+		}
+
+		r := &RepositoryMock{Messages: GenerateMessages(1)}
+		relay := NewRelay(r, p, 1, time.Millisecond,
+			WithErrorHandler(handler), WithPublisherMiddlewares(), WithLogger(NopLogger{}))
+		assert.NoError(t, relay.Run(ctx, BatchSize(10)))
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Len(t, publishErrs, 1)
+		assert.Empty(t, r.Consumed)
+	})
+
+	t.Run("Test fatal error is returned from run", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+		defer cancel()
+
+		policy := func(err error) bool {
+			var fetchErr *FetchError
+			return errors.As(err, &fetchErr)
+		}
+
+		relay := NewRelay(&fetchErrorRepositoryMock{}, &PublisherMock{}, 1, time.Millisecond,
+			WithErrorPolicy(policy), WithLogger(NopLogger{}))
+
+		err := relay.Run(ctx, BatchSize(10))
+		assert.EqualError(t, err, "fetch messages: connection refused")
+		assert.NoError(t, ctx.Err())
+	})
+
+	t.Run("Test batch timeout cancels publishing", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.TODO(), time.Millisecond*100)
+		defer cancel()
+
+		n := 10
+		r := &RepositoryMock{Messages: GenerateMessages(n)}
+		p := &slowPublisherMock{delay: 10 * time.Millisecond}
+
+		relay := NewRelay(r, p, 1, time.Hour, WithBatchTimeout(25*time.Millisecond), WithLogger(NopLogger{}))
+		assert.NoError(t, relay.Run(ctx, BatchSize(n)))
+
+		assert.Less(t, len(p.Published), n)
+		assert.Equal(t, len(p.Published), len(r.Consumed))
+	})
+}
+
+// expiringRepositoryMock records expired messages
+type expiringRepositoryMock struct {
+	RepositoryMock
+	Expired []string
+	err     error
+}
+
+func (m *expiringRepositoryMock) MarkExpired(ctx context.Context, msgs []Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+
+	for _, msg := range msgs {
+		m.Expired = append(m.Expired, msg.ID)
+	}
+
+	return nil
+}
+
+// expiryMetricsMock counts expired messages
+type expiryMetricsMock struct {
+	NopMetrics
+	expired    int
+	markFailed int
+	mu         sync.Mutex
+}
+
+func (m *expiryMetricsMock) MarkExpiredFailed(n int) {
+	m.mu.Lock()
+	m.markFailed += n
+	m.mu.Unlock()
+}
+
+func (m *expiryMetricsMock) MessageExpired(string) {
+	m.mu.Lock()
+	m.expired++
+	m.mu.Unlock()
+}
+
+func TestRelay_Expiry(t *testing.T) {
+	messages := func() []Message {
+		msgs := GenerateMessages(4)
+		for _, i := range []int{1, 3} {
+			msgs[i].ExpiresAt = sql.NullTime{Time: time.Now().Add(-time.Second), Valid: true}
+		}
+		msgs[2].ExpiresAt = sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true}
+
+		return msgs
+	}
+
+	t.Run("Test expired messages are recorded separately", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.TODO(), time.Millisecond*100)
+		defer cancel()
+
+		msgs := messages()
+		r := &expiringRepositoryMock{RepositoryMock: RepositoryMock{Messages: append([]Message{}, msgs...)}}
+		p := &PublisherMock{}
+		m := &expiryMetricsMock{}
+
+		relay := NewRelay(r, p, 1, time.Millisecond, WithMetrics(m), WithLogger(NopLogger{}))
+		assert.NoError(t, relay.Run(ctx, BatchSize(10)))
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		assert.ElementsMatch(t, []string{msgs[0].ID, msgs[2].ID}, r.Consumed)
+		assert.ElementsMatch(t, []string{msgs[1].ID, msgs[3].ID}, r.Expired)
+		assert.Len(t, p.Published, 2)
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		assert.Equal(t, 2, m.expired)
+	})
+
+	t.Run("Test failure of marking expired is reported separately", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.TODO(), time.Millisecond*100)
+		defer cancel()
+
+		r := &expiringRepositoryMock{RepositoryMock: RepositoryMock{Messages: messages()}, err: errors.New("db down")}
+		m := &expiryMetricsMock{}
+
+		var (
+			mu       sync.Mutex
+			reported []error
+		)
+		handler := func(ctx context.Context, err error) {
+			mu.Lock()
+			reported = append(reported, err)
+			mu.Unlock()
+		}
+
+		relay := NewRelay(r, &PublisherMock{}, 1, time.Hour, WithMetrics(m), WithErrorHandler(handler), WithLogger(NopLogger{}))
+		assert.NoError(t, relay.Run(ctx, BatchSize(10)))
+
+		mu.Lock()
+		defer mu.Unlock()
+		require.Len(t, reported, 1)
+		var expiredErr *MarkExpiredError
+		require.ErrorAs(t, reported[0], &expiredErr)
+		assert.Len(t, expiredErr.Messages, 2)
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		assert.Equal(t, 2, m.markFailed)
+	})
+
+	t.Run("Test expired messages are marked consumed", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.TODO(), time.Millisecond*100)
+		defer cancel()
+
+		r := &RepositoryMock{Messages: messages()}
+		p := &PublisherMock{}
+
+		relay := NewRelay(r, p, 1, time.Millisecond, WithLogger(NopLogger{}))
+		assert.NoError(t, relay.Run(ctx, BatchSize(10)))
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		assert.Len(t, r.Consumed, 4)
+		assert.Len(t, p.Published, 2)
+	})
+}
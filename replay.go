@@ -0,0 +1,202 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultReplayBatchSize = 1000
+
+	// HeaderReplayedFrom is set on copies created by ReplayCopy to id of original message
+	HeaderReplayedFrom = "x-replayed-from"
+)
+
+type ReplayMode int
+
+const (
+	// ReplayReset makes matched messages pending again
+	ReplayReset ReplayMode = iota
+	// ReplayCopy stores copies of matched messages with new ids, originals are kept consumed
+	ReplayCopy
+)
+
+// ReplayFilter selects consumed messages to replay, zero fields are ignored
+type ReplayFilter struct {
+	IDs           []string
+	EventType     string
+	Exchange      string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+func (f ReplayFilter) where() (string, []any) {
+	where, args := MessageFilter{
+		Status:        StatusConsumed,
+		EventType:     f.EventType,
+		Exchange:      f.Exchange,
+		CreatedAfter:  f.CreatedAfter,
+		CreatedBefore: f.CreatedBefore,
+	}.where()
+
+	if len(f.IDs) > 0 {
+		args = append(args, f.IDs)
+		where += fmt.Sprintf(" AND event_id = ANY($%d)", len(args))
+	}
+
+	return where, args
+}
+
+// ReplayOptions control replay execution. Messages are replayed in batches of BatchSize
+// with Interval pause between batches, so the relay is not flooded with replayed messages.
+// Expiry of original messages is not kept, replayed messages expire at ExpiresAt or never when it is zero.
+type ReplayOptions struct {
+	Mode      ReplayMode
+	DryRun    bool
+	BatchSize int
+	Interval  time.Duration
+	ExpiresAt time.Time
+}
+
+func (o ReplayOptions) expiresAt() sql.NullTime {
+	return sql.NullTime{Time: o.ExpiresAt, Valid: !o.ExpiresAt.IsZero()}
+}
+
+// Replay publishes consumed messages matching filter again and returns number of replayed messages.
+// In dry run mode it only returns number of matching messages.
+func (r *Repository) Replay(ctx context.Context, filter ReplayFilter, opts ReplayOptions) (int64, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultReplayBatchSize
+	}
+
+	if opts.DryRun {
+		where, args := filter.where()
+		query := fmt.Sprintf("SELECT event_id FROM %s WHERE %s", TableName, where)
+
+		return r.count(ctx, query, args...)
+	}
+
+	switch opts.Mode {
+	case ReplayReset:
+		return r.replayReset(ctx, filter, opts)
+	case ReplayCopy:
+		return r.replayCopy(ctx, filter, opts)
+	default:
+		return 0, fmt.Errorf("unknown replay mode %d", opts.Mode)
+	}
+}
+
+func (r *Repository) replayReset(ctx context.Context, filter ReplayFilter, opts ReplayOptions) (int64, error) {
+	query := fmt.Sprintf(`
+UPDATE %s SET consumed = $2, failures = 0, last_error = NULL, last_failed_at = NULL, expires_at = $3
+WHERE event_id = ANY($1)
+RETURNING event_id`, TableName)
+
+	return r.replayBatches(ctx, filter, opts, func(ids []string) (int64, error) {
+		n, err := r.count(ctx, query, ids, statusNotConsumed, opts.expiresAt())
+		if err != nil {
+			return 0, fmt.Errorf("while replaying messages: %w", err)
+		}
+
+		return n, nil
+	})
+}
+
+func (r *Repository) replayCopy(ctx context.Context, filter ReplayFilter, opts ReplayOptions) (int64, error) {
+	query := fmt.Sprintf(`
+INSERT INTO %s (event_id, event_type, payload, exchange, routing_key, partition_key, headers, codec, compression,
+                key_id, data_key, claim_check, expires_at, priority)
+SELECT md5(random()::text || clock_timestamp()::text || event_id::text)::uuid, event_type, payload, exchange,
+       routing_key, partition_key, COALESCE(headers, '{}'::jsonb) || jsonb_build_object($2::text, event_id::text), codec,
+       compression, key_id, data_key, claim_check, $3::timestamptz, priority
+FROM %s WHERE event_id = ANY($1)
+RETURNING event_id`, TableName, TableName)
+
+	return r.replayBatches(ctx, filter, opts, func(ids []string) (int64, error) {
+		n, err := r.count(ctx, query, ids, HeaderReplayedFrom, opts.expiresAt())
+		if err != nil {
+			return 0, fmt.Errorf("while copying messages: %w", err)
+		}
+
+		return n, nil
+	})
+}
+
+// replayBatches pages through matched messages with keyset cursor that only moves forward
+// and replays every batch, so messages consumed again between batches are not replayed twice
+func (r *Repository) replayBatches(ctx context.Context, filter ReplayFilter, opts ReplayOptions, replay func(ids []string) (int64, error)) (int64, error) {
+	where, args := filter.where()
+	selectQuery := fmt.Sprintf(`
+SELECT event_id, created_at FROM %s
+WHERE %s AND (created_at, event_id) > ($%d, $%d)
+ORDER BY created_at ASC, event_id ASC LIMIT $%d`, TableName, where, len(args)+1, len(args)+2, len(args)+3)
+
+	var (
+		total     int64
+		lastAt    time.Time
+		lastID    = "00000000-0000-0000-0000-000000000000"
+		batchArgs = append(args, lastAt, lastID, opts.BatchSize)
+	)
+	for {
+		batchArgs[len(args)], batchArgs[len(args)+1] = lastAt, lastID
+
+		ids, err := r.replayBatch(ctx, selectQuery, batchArgs, &lastAt, &lastID)
+		if err != nil {
+			return total, err
+		}
+		if len(ids) == 0 {
+			return total, nil
+		}
+
+		n, err := replay(ids)
+		if err != nil {
+			return total, err
+		}
+
+		total += n
+		if len(ids) < opts.BatchSize {
+			return total, nil
+		}
+
+		if err = sleep(ctx, opts.Interval); err != nil {
+			return total, err
+		}
+	}
+}
+
+// replayBatch selects next batch of ids to replay and advances keyset position
+func (r *Repository) replayBatch(ctx context.Context, query string, args []any, lastAt *time.Time, lastID *string) ([]string, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("while quering messages to replay: %w", err)
+	}
+	defer rows.Close() //nolint
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		if err = rows.Scan(lastID, lastAt); err != nil {
+			return nil, fmt.Errorf("while scan messages to replay: %w", err)
+		}
+		ids = append(ids, *lastID)
+	}
+
+	return ids, nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
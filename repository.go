@@ -2,55 +2,198 @@ package outbox
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
-
-	"github.com/rs/zerolog/log"
 )
 
+type RepositoryOption func(r *Repository)
+
+// WithRepositoryLogger sets logger of repository, zerolog global logger is used by default
+func WithRepositoryLogger(l Logger) RepositoryOption {
+	return func(r *Repository) {
+		r.logger = l
+	}
+}
+
+// WithCompressedPayloads makes Fetch return compressed payloads as stored
+// with compression in HeaderContentEncoding header, payloads are decompressed by default
+func WithCompressedPayloads() RepositoryOption {
+	return func(r *Repository) {
+		r.keepCompressed = true
+	}
+}
+
+// WithKeyProvider sets provider of keys encrypted payloads are decrypted with
+func WithKeyProvider(p KeyProvider) RepositoryOption {
+	return func(r *Repository) {
+		r.keys = p
+	}
+}
+
+// WithBlobStore sets store payloads of claim checked messages are loaded from,
+// without it such messages are fetched with empty payload and blob key in HeaderClaimCheck header
+func WithBlobStore(s BlobStore) RepositoryOption {
+	return func(r *Repository) {
+		r.blobs = s
+	}
+}
+
 type Repository struct {
-	db DBAdapter
+	db             DBAdapter
+	logger         Logger
+	keepCompressed bool
+	keys           KeyProvider
+	blobs          BlobStore
+	priorities     PriorityWeights
 }
 
-func NewRepository(db DBAdapter) *Repository {
-	return &Repository{db: db}
+func NewRepository(db DBAdapter, opts ...RepositoryOption) *Repository {
+	r := &Repository{db: db, logger: defaultLogger()}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
 }
 
 func (r *Repository) Fetch(ctx context.Context, batchSize BatchSize) <-chan Message {
-	stream := make(chan Message, batchSize)
+	stream, errs := r.FetchWithErrors(ctx, batchSize)
+
+	logger := r.logger
+	if logger == nil {
+		logger = defaultLogger()
+	}
 
+	go func() {
+		for err := range errs {
+			logger.Error("while fetching messages", err, batchFields(ctx)...)
+		}
+	}()
+
+	return stream
+}
+
+const fetchColumns = `event_id, event_type, exchange, routing_key, partition_key, payload, consumed, created_at, headers, codec,
+compression, key_id, data_key, claim_check, COALESCE(idempotency_key, ''), publish_at, expires_at, priority`
+
+// fetchQuery returns query of due messages with placeholders of status and batch size
+// followed by returned args
+func (r *Repository) fetchQuery() (string, []any) {
+	if len(r.priorities) > 0 {
+		return priorityFetchQuery(r.priorities)
+	}
+
+	// immediate and due scheduled messages are selected separately,
+	// so each subquery is served by its partial index
 	query := fmt.Sprintf(`
-SELECT event_id, event_type, exchange, routing_key, partition_key, payload, consumed, created_at
-FROM %s
-WHERE consumed = $1 ORDER BY created_at ASC LIMIT $2
-`, TableName)
+SELECT %s FROM (
+    (SELECT * FROM %s WHERE consumed = $1 AND publish_at IS NULL ORDER BY created_at ASC LIMIT $2)
+    UNION ALL
+    (SELECT * FROM %s WHERE consumed = $1 AND publish_at <= now() ORDER BY publish_at ASC LIMIT $2)
+) due
+ORDER BY created_at ASC LIMIT $2
+`, fetchColumns, TableName, TableName)
+
+	return query, nil
+}
+
+func (r *Repository) FetchWithErrors(ctx context.Context, batchSize BatchSize) (<-chan Message, <-chan error) {
+	stream := make(chan Message, batchSize)
+	// every row may fail, followed by rows and close errors
+	errs := make(chan error, batchSize+2)
+
+	query, args := r.fetchQuery()
 
 	go func() {
+		defer close(errs)
 		defer close(stream)
 
-		rows, err := r.db.Query(ctx, query, statusNotConsumed, batchSize)
+		rows, err := r.db.Query(ctx, query, append([]any{statusNotConsumed, batchSize}, args...)...)
 		if err != nil {
-			log.Error().Err(err).Msg("while quering messages")
+			errs <- fmt.Errorf("while quering messages: %w", err)
 			return
 		}
 
 		for rows.Next() {
 			message := Message{}
+			var payload []byte
 
-			err = rows.Scan(&message.ID, &message.EventType, &message.Exchange, &message.RoutingKey, &message.PartitionKey, &message.Payload, &message.Consumed, &message.CreatedAt)
+			err = rows.Scan(&message.ID, &message.EventType, &message.Exchange, &message.RoutingKey, &message.PartitionKey, &payload, &message.Consumed, &message.CreatedAt, &message.Headers, &message.Codec, &message.Compression, &message.keyID, &message.dataKey, &message.claimCheck,
+				&message.IdempotencyKey, &message.PublishAt, &message.ExpiresAt, &message.Priority)
 			if err != nil {
-				log.Error().Err(err).Msg("while scan messages")
+				errs <- fmt.Errorf("while scan messages: %w", err)
+				continue
+			}
+			message.Payload = payload
+
+			if err = r.decode(ctx, &message); err != nil {
+				errs <- fmt.Errorf("while decoding message %s: %w", message.ID, err)
 				continue
 			}
 
 			stream <- message
 		}
 
+		if err := rowsErr(rows); err != nil {
+			errs <- fmt.Errorf("while reading messages: %w", err)
+		}
+
 		if err := rows.Close(); err != nil {
-			log.Error().Err(err).Msg("while closing rows")
+			errs <- fmt.Errorf("while closing rows: %w", err)
 		}
 	}()
 
-	return stream
+	return stream, errs
+}
+
+// restore loads claim checked payload and decrypts it,
+// payload is left as reference when repository has no blob store
+func (r *Repository) restore(ctx context.Context, message *Message) error {
+	if message.claimCheck != "" {
+		if r.blobs == nil {
+			message.Headers = message.Headers.withHeader(HeaderClaimCheck, message.claimCheck)
+			return nil
+		}
+
+		if err := rehydratePayload(ctx, r.blobs, message); err != nil {
+			return err
+		}
+	}
+
+	return decryptPayload(ctx, r.keys, message)
+}
+
+// decode restores payload stored by persister.
+// JSON payloads are returned as json.RawMessage, so they are marshaled to JSON as before codecs were introduced.
+func (r *Repository) decode(ctx context.Context, message *Message) error {
+	if err := r.restore(ctx, message); err != nil {
+		return err
+	}
+
+	if message.claimCheck != "" {
+		return nil
+	}
+
+	if message.Compression != "" {
+		if r.keepCompressed {
+			message.Headers = message.Headers.withHeader(HeaderContentEncoding, message.Compression)
+			return nil
+		}
+
+		payload, err := message.plainPayload()
+		if err != nil {
+			return err
+		}
+		message.Payload = payload
+		message.Compression = ""
+	}
+
+	if payload, ok := message.Payload.([]byte); ok && (message.Codec == "" || message.Codec == CodecJSON) {
+		message.Payload = json.RawMessage(payload)
+	}
+
+	return nil
 }
 
 func (r *Repository) MarkConsumed(ctx context.Context, msgs []Message) error {
@@ -65,7 +208,7 @@ func (r *Repository) MarkConsumed(ctx context.Context, msgs []Message) error {
 		}
 
 		if len(ids) == 1000 || i == len(msgs)-1 {
-			query := fmt.Sprintf("UPDATE %s SET consumed=? WHERE event_id IN ?", TableName)
+			query := fmt.Sprintf("UPDATE %s SET consumed = $1 WHERE event_id = ANY($2)", TableName)
 			if err := r.db.Exec(ctx, query, statusConsumed, ids); err != nil {
 				return fmt.Errorf("while update consumed status failed: %w", err)
 			}
@@ -76,3 +219,47 @@ func (r *Repository) MarkConsumed(ctx context.Context, msgs []Message) error {
 
 	return nil
 }
+
+// MarkExpired marks messages consumed and records time they were skipped as expired
+func (r *Repository) MarkExpired(ctx context.Context, msgs []Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		ids = append(ids, msg.ID)
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET consumed = $1, expired_at = now() WHERE event_id = ANY($2)", TableName)
+	if err := r.db.Exec(ctx, query, statusConsumed, ids); err != nil {
+		return fmt.Errorf("while marking messages expired: %w", err)
+	}
+
+	return nil
+}
+
+// Backlog reports due unconsumed messages, scheduled messages are due since their publish time
+func (r *Repository) Backlog(ctx context.Context) (BacklogStats, error) {
+	query := fmt.Sprintf(
+		"SELECT count(*), min(COALESCE(publish_at, created_at::timestamptz)) FROM %s WHERE consumed = $1 AND (publish_at IS NULL OR publish_at <= now())",
+		TableName,
+	)
+
+	rows, err := r.db.Query(ctx, query, statusNotConsumed)
+	if err != nil {
+		return BacklogStats{}, fmt.Errorf("while querying backlog: %w", err)
+	}
+	defer rows.Close() //nolint
+
+	stats := BacklogStats{}
+	if rows.Next() {
+		var oldest sql.NullTime
+		if err = rows.Scan(&stats.Size, &oldest); err != nil {
+			return BacklogStats{}, fmt.Errorf("while scan backlog: %w", err)
+		}
+		stats.OldestDueAt = oldest.Time
+	}
+
+	return stats, nil
+}
@@ -2,8 +2,12 @@ package outbox
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/suite"
 )
@@ -92,6 +96,376 @@ func (suite *RepositoryTestSuite) TestMarkConsumed() {
 	}
 }
 
+func (suite *RepositoryTestSuite) TestBacklog() {
+	r := NewRepository(NewPGXAdapter(suite.pgxDB))
+	suite.pollute()
+	defer suite.cleanDB()
+
+	stats, err := r.Backlog(context.Background())
+	suite.NoError(err)
+	suite.Equal(int64(2), stats.Size)
+	suite.False(stats.OldestDueAt.IsZero())
+}
+
+func (suite *RepositoryTestSuite) TestBacklogOfScheduledMessage() {
+	ctx := context.Background()
+	r := NewRepository(NewPGXAdapter(suite.pgxDB))
+	defer suite.cleanDB()
+
+	// scheduled a week ago, the first is due since a minute
+	_, err := suite.pgxDB.Exec(ctx, fmt.Sprintf(`
+INSERT INTO %s (event_id, event_type, exchange, routing_key, payload, created_at, publish_at)
+VALUES ($1, 'TestEvent', 'test', 'test', '{}', LOCALTIMESTAMP - interval '7 days', now() - interval '1 minute'),
+       ($2, 'TestEvent', 'test', 'test', '{}', LOCALTIMESTAMP - interval '7 days', now() + interval '1 day')`,
+		TableName,
+	), "f53ec986-345f-48a4-b248-430a7d7f3420", "f53ec986-345f-48a4-b248-430a7d7f3421")
+	suite.Require().NoError(err)
+
+	backlog, err := r.Backlog(ctx)
+	suite.Require().NoError(err)
+	suite.Equal(int64(1), backlog.Size)
+	suite.Less(backlog.OldestAge(time.Now()), time.Hour)
+
+	stats, err := r.Stats(ctx)
+	suite.Require().NoError(err)
+	suite.Require().Len(stats, 1)
+	suite.Equal(int64(1), stats[0].Scheduled)
+	suite.Less(time.Since(stats[0].OldestPendingAt), time.Hour)
+}
+
+func (suite *RepositoryTestSuite) TestMaintenance() {
+	ctx := context.Background()
+	adapter := NewPGXAdapter(suite.pgxDB)
+	r := NewRepository(adapter)
+	suite.pollute()
+	defer suite.cleanDB()
+
+	applied, err := Migrate(ctx, adapter)
+	suite.NoError(err)
+	suite.Empty(applied)
+
+	stats, err := r.Stats(ctx)
+	suite.NoError(err)
+	suite.Require().Len(stats, 1)
+	suite.Equal("test", stats[0].Exchange)
+	suite.Equal("TestEvent", stats[0].EventType)
+	suite.Equal(int64(2), stats[0].Pending)
+	suite.Equal(int64(1), stats[0].Consumed)
+
+	purged, err := r.PurgeConsumed(ctx, time.Now().Add(-time.Hour))
+	suite.NoError(err)
+	suite.Equal(int64(0), purged)
+
+	purged, err = r.PurgeConsumed(ctx, time.Now().Add(time.Hour))
+	suite.NoError(err)
+	suite.Equal(int64(1), purged)
+}
+
+func (suite *RepositoryTestSuite) TestReplay() {
+	ctx := context.Background()
+	r := NewRepository(NewPGXAdapter(suite.pgxDB))
+	suite.pollute()
+	defer suite.cleanDB()
+
+	filter := ReplayFilter{EventType: "TestEvent", CreatedBefore: time.Now().Add(time.Hour)}
+
+	n, err := r.Replay(ctx, filter, ReplayOptions{DryRun: true})
+	suite.NoError(err)
+	suite.Equal(int64(1), n)
+
+	n, err = r.Replay(ctx, filter, ReplayOptions{Mode: ReplayCopy})
+	suite.NoError(err)
+	suite.Equal(int64(1), n)
+
+	copies, err := r.List(ctx, MessageFilter{Status: StatusPending})
+	suite.NoError(err)
+	suite.Len(copies, 3)
+
+	n, err = r.Replay(ctx, ReplayFilter{IDs: []string{"f53ec986-345f-48a4-b248-430a7d7f342b"}}, ReplayOptions{BatchSize: 1})
+	suite.NoError(err)
+	suite.Equal(int64(1), n)
+
+	n, err = r.Replay(ctx, filter, ReplayOptions{DryRun: true})
+	suite.NoError(err)
+	suite.Equal(int64(0), n)
+}
+
+func (suite *RepositoryTestSuite) TestReplayResetWhileRelayConsumes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	r := NewRepository(NewPGXAdapter(suite.pgxDB))
+	defer suite.cleanDB()
+
+	messages := make([]Message, 0, 3)
+	for i := 0; i < 3; i++ {
+		messages = append(messages, Message{ID: fmt.Sprintf("f53ec986-345f-48a4-b248-%012d", i), EventType: "TestEvent", Payload: `{}`})
+	}
+	err := NewPgxPersister(suite.pgxDB).PersistInTx(ctx, func(tx pgx.Tx) ([]Message, error) {
+		return messages, nil
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(r.MarkConsumed(ctx, messages))
+
+	// relay consumes replayed messages again between batches
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ctx.Err() == nil {
+			_ = r.MarkConsumed(ctx, messages)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	n, err := r.Replay(ctx, ReplayFilter{EventType: "TestEvent"}, ReplayOptions{BatchSize: 1, Interval: 50 * time.Millisecond})
+	cancel()
+	<-done
+
+	suite.NoError(err)
+	suite.Equal(int64(3), n)
+}
+
+func (suite *RepositoryTestSuite) TestReplayExpiry() {
+	ctx := context.Background()
+	r := NewRepository(NewPGXAdapter(suite.pgxDB))
+	defer suite.cleanDB()
+
+	p := NewPgxPersister(suite.pgxDB)
+	err := p.PersistInTx(ctx, func(tx pgx.Tx) ([]Message, error) {
+		return []Message{{
+			ID:        "f53ec986-345f-48a4-b248-430a7d7f342f",
+			EventType: "TestEvent",
+			Payload:   `{}`,
+			ExpiresAt: sql.NullTime{Time: time.Now().Add(-time.Hour), Valid: true},
+		}}, nil
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(r.MarkConsumed(ctx, []Message{{ID: "f53ec986-345f-48a4-b248-430a7d7f342f"}}))
+
+	filter := ReplayFilter{IDs: []string{"f53ec986-345f-48a4-b248-430a7d7f342f"}}
+	n, err := r.Replay(ctx, filter, ReplayOptions{Mode: ReplayCopy})
+	suite.Require().NoError(err)
+	suite.Equal(int64(1), n)
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	n, err = r.Replay(ctx, filter, ReplayOptions{Mode: ReplayCopy, ExpiresAt: expiresAt})
+	suite.Require().NoError(err)
+	suite.Equal(int64(1), n)
+
+	copies, err := r.List(ctx, MessageFilter{Status: StatusPending})
+	suite.Require().NoError(err)
+	suite.Require().Len(copies, 2)
+	suite.False(copies[0].ExpiresAt.Valid && copies[1].ExpiresAt.Valid)
+	suite.True(copies[0].ExpiresAt.Valid || copies[1].ExpiresAt.Valid)
+}
+
+func (suite *RepositoryTestSuite) TestRotateKey() {
+	ctx := context.Background()
+	defer suite.cleanDB()
+
+	keys, err := NewLocalKeyProvider("key-1", make([]byte, 32))
+	suite.Require().NoError(err)
+
+	p := NewPgxPersister(suite.pgxDB, WithEncryption(keys))
+	err = p.PersistInTx(ctx, func(tx pgx.Tx) ([]Message, error) {
+		return []Message{{ID: "f53ec986-345f-48a4-b248-430a7d7f342f", Payload: `{"a":"b"}`}}, nil
+	})
+	suite.Require().NoError(err)
+
+	r := NewRepository(NewPGXAdapter(suite.pgxDB), WithKeyProvider(keys))
+
+	_, err = r.RotateKey(ctx, "key-1")
+	suite.ErrorIs(err, ErrKeyStillCurrent)
+
+	suite.Require().NoError(keys.Rotate("key-2", []byte("0123456789abcdef0123456789abcdef")))
+	n, err := r.RotateKey(ctx, "key-1")
+	suite.NoError(err)
+	suite.Equal(int64(1), n)
+
+	record, err := r.Get(ctx, "f53ec986-345f-48a4-b248-430a7d7f342f")
+	suite.NoError(err)
+	suite.Equal(`{"a":"b"}`, string(record.Payload.([]byte)))
+}
+
+func (suite *RepositoryTestSuite) TestFetchScheduled() {
+	ctx := context.Background()
+	defer suite.cleanDB()
+
+	p := NewPgxPersister(suite.pgxDB)
+	err := p.PersistInTx(ctx, func(tx pgx.Tx) ([]Message, error) {
+		return []Message{
+			{ID: "f53ec986-345f-48a4-b248-430a7d7f342d", Payload: `{}`},
+			{ID: "f53ec986-345f-48a4-b248-430a7d7f342e", Payload: `{}`, PublishAt: sql.NullTime{Time: time.Now().Add(-time.Minute), Valid: true}},
+			{ID: "f53ec986-345f-48a4-b248-430a7d7f342f", Payload: `{}`, PublishAt: sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true}},
+		}, nil
+	})
+	suite.Require().NoError(err)
+
+	r := NewRepository(NewPGXAdapter(suite.pgxDB))
+	fetched := make([]string, 0)
+	for m := range r.Fetch(ctx, 100) {
+		fetched = append(fetched, m.ID)
+	}
+	suite.ElementsMatch([]string{"f53ec986-345f-48a4-b248-430a7d7f342d", "f53ec986-345f-48a4-b248-430a7d7f342e"}, fetched)
+
+	backlog, err := r.Backlog(ctx)
+	suite.NoError(err)
+	suite.Equal(int64(2), backlog.Size)
+}
+
+func (suite *RepositoryTestSuite) TestFetchPriority() {
+	ctx := context.Background()
+	defer suite.cleanDB()
+
+	p := NewPgxPersister(suite.pgxDB)
+	err := p.PersistInTx(ctx, func(tx pgx.Tx) ([]Message, error) {
+		messages := make([]Message, 0, 10)
+		for i := 0; i < 10; i++ {
+			msg := Message{ID: fmt.Sprintf("f53ec986-345f-48a4-b248-%012d", i), Payload: `{}`}
+			if i >= 8 {
+				msg.Priority = 10
+			}
+			messages = append(messages, msg)
+		}
+		return messages, nil
+	})
+	suite.Require().NoError(err)
+
+	r := NewRepository(NewPGXAdapter(suite.pgxDB), WithPriorityFetching(PriorityWeights{10: 3, 0: 1}))
+	fetched := make([]string, 0)
+	for m := range r.Fetch(ctx, 4) {
+		fetched = append(fetched, m.ID)
+	}
+	suite.Equal([]string{
+		"f53ec986-345f-48a4-b248-000000000008",
+		"f53ec986-345f-48a4-b248-000000000009",
+		"f53ec986-345f-48a4-b248-000000000000",
+		"f53ec986-345f-48a4-b248-000000000001",
+	}, fetched)
+}
+
+func (suite *RepositoryTestSuite) TestListAfterCursor() {
+	ctx := context.Background()
+	defer suite.cleanDB()
+
+	p := NewPgxPersister(suite.pgxDB)
+	err := p.PersistInTx(ctx, func(tx pgx.Tx) ([]Message, error) {
+		messages := make([]Message, 0, 5)
+		for i := 0; i < 5; i++ {
+			messages = append(messages, Message{ID: fmt.Sprintf("f53ec986-345f-48a4-b248-%012d", i), Payload: `{}`})
+		}
+		return messages, nil
+	})
+	suite.Require().NoError(err)
+
+	// messages persisted in one transaction share created_at, so pages are split by seq
+	r := NewRepository(NewPGXAdapter(suite.pgxDB))
+	seen := make([]string, 0)
+	var cursor int64
+	for i := 0; i < 10; i++ {
+		records, err := r.List(ctx, MessageFilter{AfterSeq: cursor, Limit: 2})
+		suite.Require().NoError(err)
+		if len(records) == 0 {
+			break
+		}
+
+		for _, rec := range records {
+			seen = append(seen, rec.ID)
+			cursor = rec.Seq
+		}
+	}
+
+	suite.Equal([]string{
+		"f53ec986-345f-48a4-b248-000000000000",
+		"f53ec986-345f-48a4-b248-000000000001",
+		"f53ec986-345f-48a4-b248-000000000002",
+		"f53ec986-345f-48a4-b248-000000000003",
+		"f53ec986-345f-48a4-b248-000000000004",
+	}, seen)
+}
+
+func (suite *RepositoryTestSuite) TestMarkExpired() {
+	ctx := context.Background()
+	r := NewRepository(NewPGXAdapter(suite.pgxDB))
+	suite.pollute()
+	defer suite.cleanDB()
+
+	suite.NoError(r.MarkExpired(ctx, []Message{{ID: "f53ec986-345f-48a4-b248-430a7d7f342a"}}))
+
+	expired, err := r.List(ctx, MessageFilter{Status: StatusExpired})
+	suite.NoError(err)
+	suite.Require().Len(expired, 1)
+	suite.Equal("f53ec986-345f-48a4-b248-430a7d7f342a", expired[0].ID)
+	suite.NotNil(expired[0].ExpiredAt)
+
+	consumed, err := r.List(ctx, MessageFilter{Status: StatusConsumed})
+	suite.NoError(err)
+	suite.Len(consumed, 1)
+
+	_, err = suite.pgxDB.Exec(ctx, fmt.Sprintf("UPDATE %s SET expires_at = now() - interval '1 hour' WHERE event_id = $1", TableName),
+		"f53ec986-345f-48a4-b248-430a7d7f342a")
+	suite.Require().NoError(err)
+
+	n, err := r.Requeue(ctx, []string{"f53ec986-345f-48a4-b248-430a7d7f342a"})
+	suite.NoError(err)
+	suite.Equal(int64(1), n)
+
+	record, err := r.Get(ctx, "f53ec986-345f-48a4-b248-430a7d7f342a")
+	suite.Require().NoError(err)
+	suite.Equal(StatusPending, record.Status)
+	suite.Nil(record.ExpiredAt)
+	suite.False(record.ExpiresAt.Valid)
+
+	stats, err := r.Stats(ctx)
+	suite.Require().NoError(err)
+	suite.Require().Len(stats, 1)
+	suite.Zero(stats[0].Expired)
+}
+
+func (suite *RepositoryTestSuite) TestAdmin() {
+	r := NewRepository(NewPGXAdapter(suite.pgxDB))
+	suite.pollute()
+	defer suite.cleanDB()
+
+	ctx := context.Background()
+	id := "f53ec986-345f-48a4-b248-430a7d7f342a"
+
+	for i := 0; i < maxRecordedAttempts+2; i++ {
+		suite.NoError(r.RecordFailure(ctx, Message{ID: id}, fmt.Errorf("broker down %d", i)))
+	}
+
+	failed, err := r.List(ctx, MessageFilter{Status: StatusFailed})
+	suite.NoError(err)
+	suite.Require().Len(failed, 1)
+	suite.Equal(maxRecordedAttempts+2, failed[0].Failures)
+	suite.Equal(fmt.Sprintf("broker down %d", maxRecordedAttempts+1), failed[0].LastError)
+	suite.Require().Len(failed[0].Attempts, maxRecordedAttempts)
+	suite.Equal("broker down 2", failed[0].Attempts[0].Error)
+	suite.Equal(failed[0].LastError, failed[0].Attempts[maxRecordedAttempts-1].Error)
+
+	n, err := r.Requeue(ctx, []string{id})
+	suite.NoError(err)
+	suite.Equal(int64(1), n)
+
+	record, err := r.Get(ctx, id)
+	suite.NoError(err)
+	suite.Equal(StatusPending, record.Status)
+
+	n, err = r.Skip(ctx, []string{id})
+	suite.NoError(err)
+	suite.Equal(int64(1), n)
+
+	consumed, err := r.List(ctx, MessageFilter{Status: StatusConsumed})
+	suite.NoError(err)
+	suite.Len(consumed, 2)
+
+	n, err = r.Delete(ctx, []string{id})
+	suite.NoError(err)
+	suite.Equal(int64(1), n)
+
+	_, err = r.Get(ctx, id)
+	suite.ErrorIs(err, ErrMessageNotFound)
+}
+
 func TestRepositoryTestSuite(t *testing.T) {
 	suite.Run(t, new(RepositoryTestSuite))
 }
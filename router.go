@@ -0,0 +1,203 @@
+package outbox
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// defaultDeliveryCapacity is the number of partially delivered messages RoutingPublisher tracks
+const defaultDeliveryCapacity = 10000
+
+// RouteMatcher reports whether a message should be sent to a route destination
+type RouteMatcher func(message Message) bool
+
+// MatchEventTypes matches messages with one of the event types
+func MatchEventTypes(eventTypes ...string) RouteMatcher {
+	return func(message Message) bool {
+		for _, t := range eventTypes {
+			if message.EventType == t {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// MatchExchanges matches messages with one of the exchanges
+func MatchExchanges(exchanges ...string) RouteMatcher {
+	return func(message Message) bool {
+		for _, e := range exchanges {
+			if message.Exchange == e {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// MatchAll matches every message
+func MatchAll(message Message) bool {
+	return true
+}
+
+// Route is a destination of RoutingPublisher.
+// Message is considered delivered only when every matched required route acknowledged it,
+// failures of optional routes are logged and ignored.
+type Route struct {
+	Name      string
+	Publisher Publisher
+	Match     RouteMatcher
+	Required  bool
+}
+
+// RoutingPublisher sends a message to every route it matches.
+// Messages matching no route are acknowledged.
+//
+// Delivery state is tracked per destination, so when a required destination fails
+// and the relay retries the message, destinations which already acknowledged it are skipped.
+// The state lives in memory and is lost on restart, in which case the message is
+// delivered to all matched destinations again. State of the least recently delivered messages
+// is evicted once more than capacity messages are partially delivered, so messages which never
+// succeed, such as expired or dead lettered ones, do not grow it.
+type RoutingPublisher struct {
+	routes    []Route
+	delivered map[string]*list.Element
+	order     *list.List
+	capacity  int
+	logger    Logger
+	mu        sync.Mutex
+}
+
+// delivery is a set of routes which acknowledged a message
+type delivery struct {
+	messageID string
+	routes    map[string]struct{}
+}
+
+func NewRoutingPublisher(routes ...Route) *RoutingPublisher {
+	return &RoutingPublisher{
+		routes:    routes,
+		delivered: make(map[string]*list.Element),
+		order:     list.New(),
+		capacity:  defaultDeliveryCapacity,
+		logger:    defaultLogger(),
+	}
+}
+
+// SetLogger sets logger reporting optional routes failures
+func (p *RoutingPublisher) SetLogger(l Logger) {
+	p.logger = l
+}
+
+// SetDeliveryCapacity sets number of partially delivered messages tracked, 10000 by default
+func (p *RoutingPublisher) SetDeliveryCapacity(n int) {
+	if n < 1 {
+		n = 1
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.capacity = n
+	p.evict()
+}
+
+func (p *RoutingPublisher) Publish(exchange, topic string, message Message) error {
+	matched := false
+	var requiredErr error
+
+	for _, route := range p.routes {
+		if !route.Match(message) {
+			continue
+		}
+		matched = true
+
+		if p.isDelivered(message.ID, route.Name) {
+			continue
+		}
+
+		if err := route.Publisher.Publish(exchange, topic, message); err != nil {
+			if route.Required {
+				if requiredErr == nil {
+					requiredErr = fmt.Errorf("%w: publishing message %s to route %s failed", err, message.ID, route.Name)
+				}
+				continue
+			}
+
+			p.logger.Error("while publishing message to optional route", err,
+				LogField("message_id", message.ID),
+				LogField("exchange", message.Exchange),
+				LogField("route", route.Name),
+			)
+			continue
+		}
+
+		p.markDelivered(message.ID, route.Name)
+	}
+
+	if !matched {
+		p.logger.Warn("no route matched message, skipping",
+			LogField("message_id", message.ID),
+			LogField("event_type", message.EventType),
+			LogField("exchange", message.Exchange),
+		)
+	}
+
+	if requiredErr != nil {
+		return requiredErr
+	}
+
+	p.forget(message.ID)
+
+	return nil
+}
+
+func (p *RoutingPublisher) isDelivered(messageID, route string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, ok := p.delivered[messageID]
+	if !ok {
+		return false
+	}
+	_, ok = e.Value.(*delivery).routes[route]
+
+	return ok
+}
+
+func (p *RoutingPublisher) markDelivered(messageID, route string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, ok := p.delivered[messageID]
+	if !ok {
+		e = p.order.PushFront(&delivery{messageID: messageID, routes: make(map[string]struct{})})
+		p.delivered[messageID] = e
+	}
+	p.order.MoveToFront(e)
+	e.Value.(*delivery).routes[route] = struct{}{}
+
+	p.evict()
+}
+
+func (p *RoutingPublisher) forget(messageID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e, ok := p.delivered[messageID]; ok {
+		p.order.Remove(e)
+		delete(p.delivered, messageID)
+	}
+}
+
+// evict drops the least recently delivered messages above capacity
+func (p *RoutingPublisher) evict() {
+	for p.order.Len() > p.capacity {
+		e := p.order.Back()
+		p.order.Remove(e)
+		delete(p.delivered, e.Value.(*delivery).messageID)
+	}
+}
@@ -0,0 +1,99 @@
+package outbox
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingPublisherMock fails first n publishes
+type failingPublisherMock struct {
+	PublisherMock
+	failures int
+	attempts int
+	mu       sync.Mutex
+}
+
+func (p *failingPublisherMock) Publish(exchange, topic string, message Message) error {
+	p.mu.Lock()
+	p.attempts++
+	fail := p.attempts <= p.failures
+	p.mu.Unlock()
+
+	if fail {
+		return errors.New("publish failed")
+	}
+
+	return p.PublisherMock.Publish(exchange, topic, message)
+}
+
+func TestRoutingPublisher_Publish(t *testing.T) {
+	t.Run("Test routes by event type and exchange", func(t *testing.T) {
+		analytics := &PublisherMock{}
+		workflows := &PublisherMock{}
+
+		p := NewRoutingPublisher(
+			Route{Name: "analytics", Publisher: analytics, Match: MatchAll},
+			Route{Name: "workflows", Publisher: workflows, Match: MatchEventTypes("OrderCreated"), Required: true},
+		)
+
+		assert.NoError(t, p.Publish("orders", "orders", Message{ID: "1", EventType: "OrderCreated"}))
+		assert.NoError(t, p.Publish("orders", "orders", Message{ID: "2", EventType: "OrderViewed"}))
+
+		assert.Len(t, analytics.Published, 2)
+		assert.Len(t, workflows.Published, 1)
+	})
+
+	t.Run("Test acknowledged destinations are skipped on retry", func(t *testing.T) {
+		analytics := &PublisherMock{}
+		workflows := &failingPublisherMock{failures: 1}
+
+		p := NewRoutingPublisher(
+			Route{Name: "analytics", Publisher: analytics, Match: MatchAll, Required: true},
+			Route{Name: "workflows", Publisher: workflows, Match: MatchExchanges("orders"), Required: true},
+		)
+
+		msg := Message{ID: "1", EventType: "OrderCreated", Exchange: "orders"}
+		assert.Error(t, p.Publish("orders", "orders", msg))
+		assert.NoError(t, p.Publish("orders", "orders", msg))
+
+		assert.Len(t, analytics.Published, 1)
+		assert.Len(t, workflows.Published, 1)
+		assert.Empty(t, p.delivered)
+	})
+
+	t.Run("Test delivery state of never delivered messages is bounded", func(t *testing.T) {
+		analytics := &PublisherMock{}
+		workflows := &failingPublisherMock{failures: 1000}
+
+		p := NewRoutingPublisher(
+			Route{Name: "analytics", Publisher: analytics, Match: MatchAll, Required: true},
+			Route{Name: "workflows", Publisher: workflows, Match: MatchAll, Required: true},
+		)
+		p.SetDeliveryCapacity(2)
+
+		for _, id := range []string{"1", "2", "3"} {
+			assert.Error(t, p.Publish("orders", "orders", Message{ID: id}))
+		}
+		assert.Len(t, p.delivered, 2)
+		assert.Equal(t, 2, p.order.Len())
+
+		assert.True(t, p.isDelivered("3", "analytics"))
+		assert.False(t, p.isDelivered("1", "analytics"))
+	})
+
+	t.Run("Test optional destination failure is ignored", func(t *testing.T) {
+		analytics := &failingPublisherMock{failures: 1}
+		workflows := &PublisherMock{}
+
+		p := NewRoutingPublisher(
+			Route{Name: "analytics", Publisher: analytics, Match: MatchAll},
+			Route{Name: "workflows", Publisher: workflows, Match: MatchAll, Required: true},
+		)
+
+		assert.NoError(t, p.Publish("orders", "orders", Message{ID: "1"}))
+		assert.Len(t, workflows.Published, 1)
+	})
+}
@@ -0,0 +1,251 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Migration is a versioned change of outbox table schema
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(table string) string
+}
+
+// Migrations are applied in order by Migrate, TableName is used as outbox table
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create outbox table",
+		Up: func(table string) string {
+			return fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s
+(
+    id            bigserial primary key,
+    event_id      uuid                                   not null,
+    consumed      boolean      default false             not null,
+    event_type    varchar(255)                           not null,
+    payload       jsonb                                  not null,
+    exchange      varchar(255)                           not null,
+    routing_key   varchar(255)                           not null,
+    partition_key bigint,
+    created_at    timestamp(0) default CURRENT_TIMESTAMP not null
+)`, table)
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add headers",
+		Up: func(table string) string {
+			return fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS headers jsonb", table)
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add publish failures",
+		Up: func(table string) string {
+			return fmt.Sprintf(`
+ALTER TABLE %s
+    ADD COLUMN IF NOT EXISTS failures integer default 0 not null,
+    ADD COLUMN IF NOT EXISTS last_error text,
+    ADD COLUMN IF NOT EXISTS last_failed_at timestamp(0)`, table)
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add unconsumed messages index",
+		Up: func(table string) string {
+			return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_unconsumed_idx ON %s (created_at) WHERE consumed = false", table, table)
+		},
+	},
+	// rewrites the table under ACCESS EXCLUSIVE lock, see README for converting large tables online
+	{
+		Version: 5,
+		Name:    "store encoded payload with codec",
+		Up: func(table string) string {
+			return fmt.Sprintf(`
+ALTER TABLE %s
+    ALTER COLUMN payload TYPE bytea USING convert_to(payload::text, 'UTF8'),
+    ADD COLUMN IF NOT EXISTS codec varchar(64) default 'json' not null`, table)
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add payload compression",
+		Up: func(table string) string {
+			return fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS compression varchar(16) default '' not null", table)
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add payload encryption",
+		Up: func(table string) string {
+			return fmt.Sprintf(`
+ALTER TABLE %s
+    ADD COLUMN IF NOT EXISTS key_id varchar(255) default '' not null,
+    ADD COLUMN IF NOT EXISTS data_key bytea`, table)
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add payload claim check",
+		Up: func(table string) string {
+			return fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS claim_check varchar(1024) default '' not null", table)
+		},
+	},
+	{
+		Version: 9,
+		Name:    "add idempotency key",
+		Up: func(table string) string {
+			return fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS idempotency_key varchar(255)", table)
+		},
+	},
+	{
+		Version: 10,
+		Name:    "add idempotency key unique index",
+		Up: func(table string) string {
+			return fmt.Sprintf(
+				"CREATE UNIQUE INDEX IF NOT EXISTS %s_idempotency_key_idx ON %s (idempotency_key) WHERE idempotency_key IS NOT NULL",
+				table, table,
+			)
+		},
+	},
+	{
+		Version: 11,
+		Name:    "add scheduled publishing",
+		Up: func(table string) string {
+			return fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS publish_at timestamptz", table)
+		},
+	},
+	{
+		Version: 12,
+		Name:    "add scheduled messages index",
+		Up: func(table string) string {
+			return fmt.Sprintf(
+				"CREATE INDEX IF NOT EXISTS %s_scheduled_idx ON %s (publish_at) WHERE consumed = false AND publish_at IS NOT NULL",
+				table, table,
+			)
+		},
+	},
+	{
+		Version: 13,
+		Name:    "add immediate messages index",
+		Up: func(table string) string {
+			return fmt.Sprintf(
+				"CREATE INDEX IF NOT EXISTS %s_immediate_idx ON %s (created_at) WHERE consumed = false AND publish_at IS NULL",
+				table, table,
+			)
+		},
+	},
+	{
+		Version: 14,
+		Name:    "drop unconsumed messages index",
+		Up: func(table string) string {
+			return fmt.Sprintf("DROP INDEX IF EXISTS %s_unconsumed_idx", table)
+		},
+	},
+	{
+		Version: 15,
+		Name:    "add message expiry",
+		Up: func(table string) string {
+			return fmt.Sprintf(`
+ALTER TABLE %s
+    ADD COLUMN IF NOT EXISTS expires_at timestamptz,
+    ADD COLUMN IF NOT EXISTS expired_at timestamptz`, table)
+		},
+	},
+	{
+		Version: 16,
+		Name:    "add message priority",
+		Up: func(table string) string {
+			return fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS priority smallint default 0 not null", table)
+		},
+	},
+	{
+		Version: 17,
+		Name:    "add priority lanes index",
+		Up: func(table string) string {
+			return fmt.Sprintf(
+				"CREATE INDEX IF NOT EXISTS %s_priority_idx ON %s (priority, created_at) WHERE consumed = false",
+				table, table,
+			)
+		},
+	},
+	{
+		Version: 18,
+		Name:    "add publish attempts",
+		Up: func(table string) string {
+			return fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS attempts jsonb default '[]'::jsonb not null", table)
+		},
+	},
+	{
+		Version: 19,
+		Name:    "add encryption key index",
+		Up: func(table string) string {
+			return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_key_id_idx ON %s (key_id) WHERE key_id <> ''", table, table)
+		},
+	},
+}
+
+func migrationsTable() string {
+	return TableName + "_migrations"
+}
+
+// Migrate applies migrations not yet recorded in migrations table of TableName
+// and returns versions it applied
+func Migrate(ctx context.Context, db DBAdapter) ([]int, error) {
+	query := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s
+(
+    version    integer primary key,
+    name       varchar(255)                           not null,
+    applied_at timestamp(0) default CURRENT_TIMESTAMP not null
+)`, migrationsTable())
+	if err := db.Exec(ctx, query); err != nil {
+		return nil, fmt.Errorf("while creating migrations table: %w", err)
+	}
+
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int, 0)
+	for _, m := range Migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		// statements sent at once without arguments run in a single transaction,
+		// so migration is never applied without being recorded
+		query = fmt.Sprintf("%s;\nINSERT INTO %s (version, name) VALUES (%d, '%s')",
+			m.Up(TableName), migrationsTable(), m.Version, strings.ReplaceAll(m.Name, "'", "''"))
+		if err = db.Exec(ctx, query); err != nil {
+			return versions, fmt.Errorf("while applying migration %d %q: %w", m.Version, m.Name, err)
+		}
+
+		versions = append(versions, m.Version)
+	}
+
+	return versions, nil
+}
+
+func appliedMigrations(ctx context.Context, db DBAdapter) (map[int]struct{}, error) {
+	rows, err := db.Query(ctx, fmt.Sprintf("SELECT version FROM %s", migrationsTable()))
+	if err != nil {
+		return nil, fmt.Errorf("while quering migrations: %w", err)
+	}
+	defer rows.Close() //nolint
+
+	applied := make(map[int]struct{})
+	for rows.Next() {
+		var version int
+		if err = rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("while scan migrations: %w", err)
+		}
+		applied[version] = struct{}{}
+	}
+
+	return applied, nil
+}
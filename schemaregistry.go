@@ -0,0 +1,345 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hamba/avro/v2"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+var (
+	ErrSchemaNotFound   = errors.New("schema not found")
+	ErrSchemaValidation = errors.New("payload does not match schema")
+	ErrSchemaFraming    = errors.New("payload can not be framed with schema id")
+)
+
+// HeaderSchemaID is set by persister to id of schema message payload was validated against
+const HeaderSchemaID = "x-schema-id"
+
+const (
+	schemaWireFormatMagicByte = 0
+	schemaCacheTTL            = time.Minute
+)
+
+type SchemaType string
+
+const (
+	SchemaTypeAvro SchemaType = "AVRO"
+	SchemaTypeJSON SchemaType = "JSON"
+)
+
+// Schema is a registered schema of a subject
+type Schema struct {
+	ID      int
+	Subject string
+	Version int
+	Type    SchemaType
+	Schema  string
+}
+
+// SchemaRegistry provides latest schemas of subjects
+type SchemaRegistry interface {
+	LatestSchema(ctx context.Context, subject string) (Schema, error)
+}
+
+// SubjectNameStrategy returns schema registry subject of message
+type SubjectNameStrategy func(m Message) string
+
+// EventTypeSubject uses message event type as subject
+func EventTypeSubject(m Message) string {
+	return m.EventType
+}
+
+// SchemaValidator validates encoded payloads against latest schema of message subject
+type SchemaValidator struct {
+	registry SchemaRegistry
+	subject  SubjectNameStrategy
+	compiled map[int]any
+	mu       sync.Mutex
+}
+
+// NewSchemaValidator creates validator, subjects are event types when subject is nil
+func NewSchemaValidator(registry SchemaRegistry, subject SubjectNameStrategy) *SchemaValidator {
+	if subject == nil {
+		subject = EventTypeSubject
+	}
+
+	return &SchemaValidator{registry: registry, subject: subject, compiled: make(map[int]any)}
+}
+
+// Validate validates encoded payload and returns schema it matches.
+// JSON schemas validate JSON documents, Avro schemas validate Avro binary encoded payloads.
+func (v *SchemaValidator) Validate(ctx context.Context, m Message) (Schema, error) {
+	schema, err := v.registry.LatestSchema(ctx, v.subject(m))
+	if err != nil {
+		return Schema{}, err
+	}
+
+	payload, err := encodedPayload(m)
+	if err != nil {
+		return Schema{}, err
+	}
+
+	compiled, err := v.compile(schema)
+	if err != nil {
+		return Schema{}, err
+	}
+
+	switch s := compiled.(type) {
+	case *jsonschema.Schema:
+		var doc any
+		if err = json.Unmarshal(payload, &doc); err == nil {
+			err = s.Validate(doc)
+		}
+	case avro.Schema:
+		err = validateAvro(s, payload)
+	}
+	if err != nil {
+		return Schema{}, fmt.Errorf("%w: subject %s schema %d: %s", ErrSchemaValidation, schema.Subject, schema.ID, err)
+	}
+
+	return schema, nil
+}
+
+// encodedPayload returns payload already encoded by persister as is, so codec of message need not be registered,
+// other payloads are encoded with codec of message
+func encodedPayload(m Message) ([]byte, error) {
+	switch p := m.Payload.(type) {
+	case []byte:
+		return p, nil
+	case json.RawMessage:
+		return p, nil
+	case string:
+		return []byte(p), nil
+	default:
+		return m.BytePayload()
+	}
+}
+
+// validateAvro decodes payload and checks it has no trailing data,
+// since decoder stops once value of schema is read
+func validateAvro(schema avro.Schema, payload []byte) error {
+	r := avro.NewReader(nil, 0)
+	r.Reset(payload)
+
+	var doc any
+	r.ReadVal(schema, &doc)
+	if r.Error != nil {
+		return r.Error
+	}
+
+	if r.Read(make([]byte, 1)); r.Error == nil {
+		return errors.New("payload has trailing data")
+	}
+
+	return nil
+}
+
+func (v *SchemaValidator) compile(schema Schema) (any, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if compiled, ok := v.compiled[schema.ID]; ok {
+		return compiled, nil
+	}
+
+	var (
+		compiled any
+		err      error
+	)
+	switch schema.Type {
+	case SchemaTypeJSON:
+		compiled, err = jsonschema.CompileString(fmt.Sprintf("schema-%d.json", schema.ID), schema.Schema)
+	case SchemaTypeAvro, "":
+		compiled, err = avro.Parse(schema.Schema)
+	default:
+		return nil, fmt.Errorf("unsupported schema type %s", schema.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while compiling schema %d: %w", schema.ID, err)
+	}
+
+	v.compiled[schema.ID] = compiled
+
+	return compiled, nil
+}
+
+// SchemaWireFormatMiddleware prefixes payloads of messages validated against a schema
+// with magic byte and 4 byte big endian schema id as expected by schema registry aware consumers.
+// Compressed and claim checked payloads are rejected with ErrSchemaFraming.
+func SchemaWireFormatMiddleware() PublisherMiddleware {
+	return func(next Publisher) Publisher {
+		return PublisherFunc(func(exchange, topic string, message Message) error {
+			header, ok := message.Headers[HeaderSchemaID]
+			if !ok {
+				return next.Publish(exchange, topic, message)
+			}
+
+			if message.Compression != "" {
+				return fmt.Errorf("%w: payload of message %s is compressed", ErrSchemaFraming, message.ID)
+			}
+			if _, ok = message.Headers[HeaderClaimCheck]; ok {
+				return fmt.Errorf("%w: payload of message %s is claim checked", ErrSchemaFraming, message.ID)
+			}
+
+			id, err := strconv.ParseUint(header, 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid schema id %q of message %s: %w", header, message.ID, err)
+			}
+
+			payload, err := message.BytePayload()
+			if err != nil {
+				return err
+			}
+
+			framed := make([]byte, 5, 5+len(payload))
+			framed[0] = schemaWireFormatMagicByte
+			binary.BigEndian.PutUint32(framed[1:], uint32(id))
+			message.Payload = append(framed, payload...)
+
+			return next.Publish(exchange, topic, message)
+		})
+	}
+}
+
+// InMemorySchemaRegistry is a schema registry for tests
+type InMemorySchemaRegistry struct {
+	subjects map[string][]Schema
+	lastID   int
+	mu       sync.RWMutex
+}
+
+func NewInMemorySchemaRegistry() *InMemorySchemaRegistry {
+	return &InMemorySchemaRegistry{subjects: make(map[string][]Schema)}
+}
+
+// Register adds new version of subject schema
+func (r *InMemorySchemaRegistry) Register(subject string, schemaType SchemaType, schema string) Schema {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastID++
+	s := Schema{
+		ID:      r.lastID,
+		Subject: subject,
+		Version: len(r.subjects[subject]) + 1,
+		Type:    schemaType,
+		Schema:  schema,
+	}
+	r.subjects[subject] = append(r.subjects[subject], s)
+
+	return s
+}
+
+func (r *InMemorySchemaRegistry) LatestSchema(_ context.Context, subject string) (Schema, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	versions := r.subjects[subject]
+	if len(versions) == 0 {
+		return Schema{}, fmt.Errorf("%w: %s", ErrSchemaNotFound, subject)
+	}
+
+	return versions[len(versions)-1], nil
+}
+
+// ConfluentSchemaRegistry is a client of Confluent compatible schema registry REST API.
+// Latest schemas are cached for a minute.
+type ConfluentSchemaRegistry struct {
+	url    string
+	client *http.Client
+	cache  map[string]cachedSchema
+	mu     sync.Mutex
+}
+
+type cachedSchema struct {
+	schema    Schema
+	expiresAt time.Time
+}
+
+func NewConfluentSchemaRegistry(url string, client *http.Client) *ConfluentSchemaRegistry {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &ConfluentSchemaRegistry{
+		url:    strings.TrimSuffix(url, "/"),
+		client: client,
+		cache:  make(map[string]cachedSchema),
+	}
+}
+
+func (r *ConfluentSchemaRegistry) LatestSchema(ctx context.Context, subject string) (Schema, error) {
+	r.mu.Lock()
+	cached, ok := r.cache[subject]
+	r.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.schema, nil
+	}
+
+	endpoint := fmt.Sprintf("%s/subjects/%s/versions/latest", r.url, url.PathEscape(subject))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return Schema{}, fmt.Errorf("while creating schema request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return Schema{}, fmt.Errorf("while requesting schema of %s: %w", subject, err)
+	}
+	defer resp.Body.Close() //nolint
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Schema{}, fmt.Errorf("while reading schema of %s: %w", subject, err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return Schema{}, fmt.Errorf("%w: %s", ErrSchemaNotFound, subject)
+	case resp.StatusCode != http.StatusOK:
+		return Schema{}, fmt.Errorf("requesting schema of %s failed: status %d: %s", subject, resp.StatusCode, bytes.TrimSpace(body))
+	}
+
+	var result struct {
+		ID         int        `json:"id"`
+		Subject    string     `json:"subject"`
+		Version    int        `json:"version"`
+		SchemaType SchemaType `json:"schemaType"`
+		Schema     string     `json:"schema"`
+	}
+	if err = json.Unmarshal(body, &result); err != nil {
+		return Schema{}, fmt.Errorf("while decoding schema of %s: %w", subject, err)
+	}
+
+	schema := Schema{
+		ID:      result.ID,
+		Subject: result.Subject,
+		Version: result.Version,
+		Type:    result.SchemaType,
+		Schema:  result.Schema,
+	}
+	// schema type is omitted for Avro schemas
+	if schema.Type == "" {
+		schema.Type = SchemaTypeAvro
+	}
+
+	r.mu.Lock()
+	r.cache[subject] = cachedSchema{schema: schema, expiresAt: time.Now().Add(schemaCacheTTL)}
+	r.mu.Unlock()
+
+	return schema, nil
+}
@@ -0,0 +1,158 @@
+package outbox
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAvroSchema = `{
+	"type": "record",
+	"name": "OrderCreated",
+	"fields": [{"name": "order_id", "type": "string"}]
+}`
+
+func TestSchemaValidator_Validate(t *testing.T) {
+	registry := NewInMemorySchemaRegistry()
+	registry.Register("OrderCreated", SchemaTypeJSON, `{"type": "object", "required": ["order_id"]}`)
+	jsonSchema := registry.Register("OrderCreated", SchemaTypeJSON, `{
+		"type": "object",
+		"properties": {"order_id": {"type": "string"}},
+		"required": ["order_id"]
+	}`)
+	avroSchema := registry.Register("OrderCancelled", SchemaTypeAvro, testAvroSchema)
+
+	v := NewSchemaValidator(registry, nil)
+	ctx := context.Background()
+
+	t.Run("Test JSON schema", func(t *testing.T) {
+		schema, err := v.Validate(ctx, Message{EventType: "OrderCreated", Payload: `{"order_id": "1"}`})
+		require.NoError(t, err)
+		assert.Equal(t, jsonSchema.ID, schema.ID)
+
+		_, err = v.Validate(ctx, Message{EventType: "OrderCreated", Payload: `{"order_id": 1}`})
+		assert.ErrorIs(t, err, ErrSchemaValidation)
+	})
+
+	t.Run("Test Avro schema", func(t *testing.T) {
+		codec, err := NewAvroCodec("avro/order-cancelled", testAvroSchema)
+		require.NoError(t, err)
+
+		payload, err := codec.Marshal(map[string]any{"order_id": "1"})
+		require.NoError(t, err)
+
+		schema, err := v.Validate(ctx, Message{EventType: "OrderCancelled", Payload: payload})
+		require.NoError(t, err)
+		assert.Equal(t, avroSchema.ID, schema.ID)
+
+		_, err = v.Validate(ctx, Message{EventType: "OrderCancelled", Payload: []byte{0x10}})
+		assert.ErrorIs(t, err, ErrSchemaValidation)
+
+		_, err = v.Validate(ctx, Message{EventType: "OrderCancelled", Payload: append(payload, 0x00)})
+		assert.ErrorIs(t, err, ErrSchemaValidation)
+	})
+
+	t.Run("Test Avro maps and multi block arrays", func(t *testing.T) {
+		registry.Register("OrderTagged", SchemaTypeAvro, `{
+			"type": "record",
+			"name": "OrderTagged",
+			"fields": [
+				{"name": "tags", "type": {"type": "map", "values": "string"}},
+				{"name": "items", "type": {"type": "array", "items": "string"}}
+			]
+		}`)
+
+		payload := []byte{
+			0x04, 0x02, 'a', 0x02, '1', 0x02, 'b', 0x02, '2', 0x00, // map of two entries
+			0x02, 0x02, 'x', 0x01, 0x04, 0x02, 'y', 0x00, // array of two blocks, the second with byte size
+		}
+		for i := 0; i < 10; i++ {
+			_, err := v.Validate(ctx, Message{EventType: "OrderTagged", Payload: payload})
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("Test unknown subject", func(t *testing.T) {
+		_, err := v.Validate(ctx, Message{EventType: "Unknown", Payload: `{}`})
+		assert.ErrorIs(t, err, ErrSchemaNotFound)
+	})
+
+	t.Run("Test persister stores schema id", func(t *testing.T) {
+		cfg := newPersisterConfig([]PersisterOption{WithSchemaValidator(v)})
+
+		msg := Message{ID: "1", EventType: "OrderCreated", Payload: map[string]string{"order_id": "1"}}
+		require.NoError(t, cfg.prepare(ctx, &msg))
+		assert.Equal(t, "2", msg.Headers[HeaderSchemaID])
+
+		msg = Message{ID: "2", EventType: "OrderCreated", Payload: map[string]string{}}
+		assert.ErrorIs(t, cfg.prepare(ctx, &msg), ErrSchemaValidation)
+	})
+
+	t.Run("Test persister codec need not be registered", func(t *testing.T) {
+		codec, err := NewAvroCodec("avro/unregistered", testAvroSchema)
+		require.NoError(t, err)
+		_, err = CodecByName(codec.Name())
+		require.ErrorIs(t, err, ErrUnknownCodec)
+
+		cfg := newPersisterConfig([]PersisterOption{WithCodec(codec), WithSchemaValidator(v)})
+
+		msg := Message{ID: "1", EventType: "OrderCancelled", Payload: map[string]any{"order_id": "1"}}
+		require.NoError(t, cfg.prepare(ctx, &msg))
+		assert.Equal(t, strconv.Itoa(avroSchema.ID), msg.Headers[HeaderSchemaID])
+	})
+}
+
+func TestSchemaWireFormatMiddleware(t *testing.T) {
+	var published []byte
+	p := ChainPublisher(PublisherFunc(func(exchange, topic string, message Message) error {
+		published, _ = message.BytePayload()
+		return nil
+	}), SchemaWireFormatMiddleware())
+
+	require.NoError(t, p.Publish("ex", "rk", Message{Payload: []byte("{}"), Headers: Headers{HeaderSchemaID: "258"}}))
+	assert.Equal(t, byte(0), published[0])
+	assert.Equal(t, uint32(258), binary.BigEndian.Uint32(published[1:5]))
+	assert.Equal(t, "{}", string(published[5:]))
+
+	require.NoError(t, p.Publish("ex", "rk", Message{Payload: []byte("{}")}))
+	assert.Equal(t, "{}", string(published))
+
+	compressed := Message{Payload: []byte("{}"), Compression: CompressionGzip, Headers: Headers{HeaderSchemaID: "258"}}
+	assert.ErrorIs(t, p.Publish("ex", "rk", compressed), ErrSchemaFraming)
+
+	checked := Message{Headers: Headers{HeaderSchemaID: "258", HeaderClaimCheck: "1"}}
+	assert.ErrorIs(t, p.Publish("ex", "rk", checked), ErrSchemaFraming)
+}
+
+func TestConfluentSchemaRegistry_LatestSchema(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/subjects/OrderCreated/versions/latest" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error_code": 40401, "message": "Subject not found."}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"subject": "OrderCreated", "version": 3, "id": 7, "schema": "\"string\""}`))
+	}))
+	defer server.Close()
+
+	r := NewConfluentSchemaRegistry(server.URL, nil)
+
+	schema, err := r.LatestSchema(context.Background(), "OrderCreated")
+	require.NoError(t, err)
+	assert.Equal(t, Schema{ID: 7, Subject: "OrderCreated", Version: 3, Type: SchemaTypeAvro, Schema: `"string"`}, schema)
+
+	_, err = r.LatestSchema(context.Background(), "OrderCreated")
+	require.NoError(t, err)
+	assert.Equal(t, 1, requests)
+
+	_, err = r.LatestSchema(context.Background(), "Unknown")
+	assert.ErrorIs(t, err, ErrSchemaNotFound)
+}
@@ -0,0 +1,42 @@
+//go:build go1.21
+
+package outbox
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogLogger adapts slog.Logger to Logger
+type SlogLogger struct {
+	logger *slog.Logger
+}
+
+func NewSlogLogger(logger *slog.Logger) *SlogLogger {
+	return &SlogLogger{logger: logger}
+}
+
+func (l *SlogLogger) Debug(msg string, fields ...Field) {
+	l.logger.LogAttrs(context.Background(), slog.LevelDebug, msg, attrs(fields)...)
+}
+
+func (l *SlogLogger) Info(msg string, fields ...Field) {
+	l.logger.LogAttrs(context.Background(), slog.LevelInfo, msg, attrs(fields)...)
+}
+
+func (l *SlogLogger) Warn(msg string, fields ...Field) {
+	l.logger.LogAttrs(context.Background(), slog.LevelWarn, msg, attrs(fields)...)
+}
+
+func (l *SlogLogger) Error(msg string, err error, fields ...Field) {
+	l.logger.LogAttrs(context.Background(), slog.LevelError, msg, append(attrs(fields), slog.Any("error", err))...)
+}
+
+func attrs(fields []Field) []slog.Attr {
+	as := make([]slog.Attr, 0, len(fields)+1)
+	for _, f := range fields {
+		as = append(as, slog.Any(f.Key, f.Value))
+	}
+
+	return as
+}
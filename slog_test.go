@@ -0,0 +1,27 @@
+//go:build go1.21
+
+package outbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewSlogLogger(slog.New(slog.NewJSONHandler(buf, nil)))
+
+	l.Error("publish failed", errors.New("broker down"), LogField("message_id", "1"))
+
+	line := map[string]any{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "ERROR", line["level"])
+	assert.Equal(t, "publish failed", line["msg"])
+	assert.Equal(t, "broker down", line["error"])
+	assert.Equal(t, "1", line["message_id"])
+}
@@ -12,21 +12,6 @@ import (
 	"gorm.io/gorm"
 )
 
-const createTableQuery = `
-create table if not exists outbox_messages
-(
-    id         bigserial primary key, 
-    event_id          uuid                                   not null,
-    consumed    boolean      default false             not null,
-    event_type  varchar(255)                           not null,
-    payload     jsonb                                  not null,
-    exchange    varchar(255)                           not null,
-    routing_key varchar(255)                           not null,
-    partition_key bigint,
-    created_at  timestamp(0) default CURRENT_TIMESTAMP not null
-)
-`
-
 const input = `
 outbox_messages:
 - event_id: f53ec986-345f-48a4-b248-430a7d7f342a
@@ -83,13 +68,6 @@ func (suite *TestSuite) SetupTest() {
 	}
 
 	suite.db = db
-	if !suite.tableCreated {
-		_, err := db.Exec(createTableQuery)
-		if err != nil {
-			suite.Failf("failed to create table: %s", "", err)
-		}
-		suite.tableCreated = true
-	}
 
 	gormDB, err := gorm.Open(postgres.New(postgres.Config{
 		DSN: "host=127.0.0.1 user=db_user password=secretsecret dbname=outbox_test port=5432 sslmode=disable",
@@ -106,6 +84,13 @@ func (suite *TestSuite) SetupTest() {
 	}
 
 	suite.pgxDB = conn
+
+	if !suite.tableCreated {
+		if _, err = Migrate(context.Background(), NewPGXAdapter(conn)); err != nil {
+			suite.Failf("failed to migrate: %s", "", err)
+		}
+		suite.tableCreated = true
+	}
 }
 
 func (suite *TestSuite) TearDownSuite() {
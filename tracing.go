@@ -0,0 +1,78 @@
+package outbox
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/vsvp21/outbox/v5"
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// injectTraceContext stores trace context of ctx in message headers
+// using globally registered propagator
+func injectTraceContext(ctx context.Context, message *Message) {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return
+	}
+
+	headers := make(Headers, len(message.Headers)+len(carrier))
+	for k, v := range message.Headers {
+		headers[k] = v
+	}
+	for k, v := range carrier {
+		headers[k] = v
+	}
+
+	message.Headers = headers
+}
+
+// extractTraceContext returns ctx with trace context stored in message headers
+func extractTraceContext(ctx context.Context, message Message) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(message.Headers))
+}
+
+// startPersistSpan starts span wrapping persisting of messages,
+// its context is stored with every persisted message
+func startPersistSpan(ctx context.Context) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "outbox persist", trace.WithSpanKind(trace.SpanKindInternal))
+}
+
+// startPublishSpan starts a child span of the trace stored with message,
+// linked to the span of ctx when there is one
+func startPublishSpan(ctx context.Context, message Message) (context.Context, trace.Span) {
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "outbox"),
+			attribute.String("messaging.destination.name", message.Exchange),
+			attribute.String("messaging.message.id", message.ID),
+			attribute.String("outbox.event_type", message.EventType),
+			attribute.String("outbox.routing_key", message.RoutingKey),
+		),
+	}
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: sc}))
+	}
+
+	return tracer().Start(extractTraceContext(ctx, message), "outbox publish "+message.Exchange, opts...)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
@@ -0,0 +1,55 @@
+package outbox
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestRelay_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	}()
+
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Millisecond*500)
+	defer cancel()
+
+	reqCtx, reqSpan := tp.Tracer("test").Start(context.Background(), "request")
+	msg := GenerateMessages(1)[0]
+	injectTraceContext(reqCtx, &msg)
+	reqSpan.End()
+	assert.NotEmpty(t, msg.Headers["traceparent"])
+
+	r := &RepositoryMock{Messages: []Message{msg}}
+	p := &PublisherMock{}
+
+	relay := NewRelay(r, p, runtime.NumCPU(), time.Millisecond)
+	assert.NoError(t, relay.Run(ctx, BatchSize(10)))
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+	publishSpan := spans[1]
+	assert.Equal(t, reqSpan.SpanContext().TraceID(), publishSpan.SpanContext().TraceID())
+	assert.Equal(t, reqSpan.SpanContext().SpanID(), publishSpan.Parent().SpanID())
+
+	assert.Len(t, p.Published, 1)
+	published := extractTraceContext(context.Background(), p.Published[0])
+	assert.Equal(t, publishSpan.SpanContext().SpanID(), spanIDFromContext(published))
+}
+
+func spanIDFromContext(ctx context.Context) trace.SpanID {
+	return trace.SpanContextFromContext(ctx).SpanID()
+}